@@ -0,0 +1,345 @@
+package beep
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of pitch classes from C, spelled with flats as in Piano.noteKeyMap
+var pitchNames = []string{"C", "Db", "D", "Eb", "E", "F", "Gb", "G", "Ab", "A", "Bb", "B"}
+
+// Names of pitch classes from C, spelled with sharps
+var sharpPitchNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// Chord qualities at start of chord symbol after root, longest first
+var chordQualities = []string{"mmaj", "mMaj", "maj", "min", "dim", "aug", "mM", "m", "M", "Δ", "-", "+", "o", "°", "ø"}
+
+// Alterations and added notes after chord quality, longest first
+var chordAlterations = []string{"add13", "add11", "add9", "add2", "sus2", "sus4", "sus", "no3", "no5",
+	"#11", "b13", "b5", "#5", "b9", "#9"}
+
+// Chord played from a chord symbol like Cmaj7, Am/G or F/1:open
+type chordSymbol struct {
+	root      int    // pitch class of root, C is 0
+	intervals []int  // semitones of chord notes from root
+	bass      int    // pitch class of slash bass, -1 if none
+	inversion int    // notes moved an octave up from the bottom
+	voicing   string // "close", "open" or "drop2"
+	pattern   string // "up" or "down" plays notes one by one, empty plays a chord
+	octave    int    // octaves of root from octave of hand
+}
+
+// Returns pitch class of note name like C, F# or Bb at start of s and its length
+func parsePitchName(s string) (int, int) {
+	if len(s) == 0 || s[0] < 'A' || s[0] > 'G' {
+		return -1, 0
+	}
+	pitch := []int{9, 11, 0, 2, 4, 5, 7}[s[0]-'A']
+	if len(s) > 1 {
+		switch s[1] {
+		case '#':
+			return (pitch + 1) % 12, 2
+		case 'b':
+			return (pitch + 11) % 12, 2
+		}
+	}
+	return pitch, 1
+}
+
+// Returns index of > closing chord symbol starting with < at keys[k].
+// Chord symbols have no spaces, an unclosed symbol ends before the next
+// space or at the end of keys and found is false.
+func chordSymbolEnd(keys []rune, k int) (int, bool) {
+	end := k + 1
+	for end < len(keys) && keys[end] != '>' && keys[end] != ' ' && keys[end] != '\t' {
+		end++
+	}
+	if end < len(keys) && keys[end] == '>' {
+		return end, true
+	}
+	return end - 1, false
+}
+
+// Parses chord symbol like Cmaj7, Am/G, G7/1, C:open:up or C:+1
+func parseChordSymbol(symbol string) (*chordSymbol, error) {
+	fields := strings.Split(symbol, ":")
+	name := fields[0]
+	c := &chordSymbol{bass: -1, voicing: "close"}
+	root, n := parsePitchName(name)
+	if n == 0 {
+		return nil, fmt.Errorf("chord %s has no root note", symbol)
+	}
+	c.root = root
+	rest := name[n:]
+
+	third, fifth, seventh := 4, 7, 10
+	major7 := false
+	var added []int
+	quality := ""
+	for _, q := range chordQualities {
+		if strings.HasPrefix(rest, q) {
+			quality = q
+			rest = rest[len(q):]
+			break
+		}
+	}
+	switch quality {
+	case "mmaj", "mMaj", "mM":
+		third, major7 = 3, true
+	case "maj", "M", "Δ":
+		major7 = true
+	case "min", "m", "-":
+		third = 3
+	case "dim", "o", "°":
+		third, fifth, seventh = 3, 6, 9
+	case "ø":
+		third, fifth = 3, 6
+		added = append(added, 10)
+	case "aug", "+":
+		fifth = 8
+	}
+	if major7 {
+		seventh = 11
+	}
+
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 {
+		switch rest[:digits] {
+		case "5":
+			third = 0
+		case "6":
+			added = append(added, 9)
+		case "69":
+			added = append(added, 9, 14)
+		case "7":
+			added = append(added, seventh)
+		case "9":
+			added = append(added, seventh, 14)
+		case "11":
+			added = append(added, seventh, 14, 17)
+		case "13":
+			added = append(added, seventh, 14, 21)
+		default:
+			return nil, fmt.Errorf("chord %s has unknown extension %s", symbol, rest[:digits])
+		}
+		rest = rest[digits:]
+	}
+
+	for len(rest) > 0 && rest[0] != '/' {
+		alteration := ""
+		for _, a := range chordAlterations {
+			if strings.HasPrefix(rest, a) {
+				alteration = a
+				break
+			}
+		}
+		switch alteration {
+		case "":
+			return nil, fmt.Errorf("chord %s has unknown quality %s", symbol, rest)
+		case "sus2":
+			third = 2
+		case "sus4", "sus":
+			third = 5
+		case "no3":
+			third = 0
+		case "no5":
+			fifth = 0
+		case "b5":
+			fifth = 6
+		case "#5":
+			fifth = 8
+		case "add2":
+			added = append(added, 2)
+		default:
+			added = append(added, map[string]int{"add9": 14, "add11": 17, "add13": 21,
+				"b9": 13, "#9": 15, "#11": 18, "b13": 20}[alteration])
+		}
+		rest = rest[len(alteration):]
+	}
+
+	if len(rest) > 0 {
+		// slash bass like /G or inversion like /1
+		slash := rest[1:]
+		if inversion, err := strconv.Atoi(slash); err == nil && inversion >= 0 {
+			c.inversion = inversion
+		} else if bass, n := parsePitchName(slash); n > 0 && n == len(slash) {
+			c.bass = bass
+		} else {
+			return nil, fmt.Errorf("chord %s has invalid bass %s", symbol, slash)
+		}
+	}
+
+	c.intervals = []int{0}
+	for _, interval := range append([]int{third, fifth}, added...) {
+		if interval > 0 && !containsInt(c.intervals, interval) {
+			c.intervals = append(c.intervals, interval)
+		}
+	}
+	sort.Ints(c.intervals)
+
+	for _, option := range fields[1:] {
+		switch option {
+		case "close", "open", "drop2":
+			c.voicing = option
+		case "up", "down":
+			c.pattern = option
+		default:
+			octave, err := strconv.Atoi(option)
+			if err != nil || option[0] != '+' && option[0] != '-' {
+				return nil, fmt.Errorf("chord %s has unknown option %s", symbol, option)
+			}
+			c.octave = octave
+		}
+	}
+	return c, nil
+}
+
+// Returns MIDI note numbers of chord with root in octave, lowest first
+func (c *chordSymbol) notes(octave int) []int {
+	root := 12*(octave+1) + c.root
+	var notes []int
+	for _, interval := range c.intervals {
+		notes = append(notes, root+interval)
+	}
+	for i := 0; i < c.inversion; i++ {
+		notes = append(notes[1:], notes[0]+12)
+	}
+	switch {
+	case c.voicing == "open" && len(notes) > 2:
+		notes[1] += 12
+	case c.voicing == "drop2" && len(notes) > 2:
+		notes[len(notes)-2] -= 12
+	}
+	sort.Ints(notes)
+	if c.bass >= 0 {
+		bass := notes[0] - 1
+		for (bass%12+12)%12 != c.bass {
+			bass--
+		}
+		notes = append([]int{bass}, notes...)
+	}
+	return notes
+}
+
+// Returns number of notes the chord plays
+func (c *chordSymbol) size() int {
+	if c.bass >= 0 {
+		return len(c.intervals) + 1
+	}
+	return len(c.intervals)
+}
+
+// Returns octave of chord roots played by hand
+func handChordOctave(hand rune) int {
+	switch hand {
+	case '0':
+		return 1
+	case 'L':
+		return 3
+	case '7':
+		return 7
+	}
+	return 4
+}
+
+// Returns notation of chord symbol played by hand, like "C3qet" for C.
+// Keys are found by note names of noteKeyMap, articulation is added to
+// the first note of a chord or to all notes of a pattern.
+func chordNotation(symbol string, noteKeyMap map[string]rune, hand, articulation rune) (string, error) {
+	c, err := parseChordSymbol(symbol)
+	if err != nil {
+		return "", err
+	}
+	notes := c.notes(handChordOctave(hand) + c.octave)
+	if c.pattern == "down" {
+		for i, j := 0, len(notes)-1; i < j; i, j = i+1, j-1 {
+			notes[i], notes[j] = notes[j], notes[i]
+		}
+	}
+	var buf strings.Builder
+	if c.pattern == "" {
+		if len(notes) > 9 {
+			return "", fmt.Errorf("chord %s has more than 9 notes", symbol)
+		}
+		buf.WriteString("C" + strconv.Itoa(len(notes)))
+	}
+	current := hand
+	for i, number := range notes {
+		name := ""
+		if number >= 0 {
+			name = pitchNames[number%12] + strconv.Itoa(number/12-1)
+		}
+		key, found := noteKeyMap[name]
+		if !found {
+			return "", fmt.Errorf("chord %s is out of range", symbol)
+		}
+		level := key / 1000 * 1000
+		if h := keyLevelHand(level); h != current {
+			buf.WriteString("H" + string(h))
+			current = h
+		}
+		buf.WriteRune(key - level)
+		if articulation != 0 && (i == 0 || c.pattern != "") {
+			buf.WriteRune(articulation)
+		}
+	}
+	if current != hand {
+		buf.WriteString("H" + string(hand))
+	}
+	return buf.String(), nil
+}
+
+// Transposes root and slash bass of chord symbol like <Am/G> by semitones,
+// octave option like :+1 is changed when root moves past C
+func transposeChordSymbol(text string, semitones int) string {
+	transpose := func(s string) string {
+		pitch, n := parsePitchName(s)
+		if n == 0 {
+			return s
+		}
+		names := pitchNames
+		if n == 2 && s[1] == '#' {
+			names = sharpPitchNames
+		}
+		return names[((pitch+semitones)%12+12)%12] + s[n:]
+	}
+	end := strings.Index(text, ">")
+	if !strings.HasPrefix(text, "<") || end < 0 {
+		return text
+	}
+	inner, suffix := text[1:end], text[end:]
+	fields := strings.Split(inner, ":")
+	name := fields[0]
+	if slash := strings.Index(name, "/"); slash >= 0 {
+		name = transpose(name[:slash]) + "/" + transpose(name[slash+1:])
+	} else {
+		name = transpose(name)
+	}
+
+	// octave option keeps root at transposed pitch when it passes C
+	octave := 0
+	if root, n := parsePitchName(fields[0]); n > 0 {
+		octave = root + semitones
+		if octave < 0 {
+			octave -= 11
+		}
+		octave /= 12
+	}
+	symbol := []string{name}
+	for _, option := range fields[1:] {
+		if n, err := strconv.Atoi(option); err == nil && (option[0] == '+' || option[0] == '-') {
+			octave += n
+		} else {
+			symbol = append(symbol, option)
+		}
+	}
+	if octave != 0 {
+		symbol = append(symbol, fmt.Sprintf("%+d", octave))
+	}
+	return "<" + strings.Join(symbol, ":") + suffix
+}
@@ -0,0 +1,57 @@
+package beep
+
+import (
+	"fmt"
+)
+
+func Example_chordNotation() {
+	piano := NewPiano()
+	for _, symbol := range []string{"C", "Cmaj7", "Am/G", "G7/1", "Dm7b5", "Csus4:open", "F:up"} {
+		text, err := chordNotation(symbol, piano.noteKeyMap, 'R', 0)
+		fmt.Println(symbol, text, err)
+	}
+	fmt.Println(chordNotation("C", piano.noteKeyMap, 'L', '\''))
+	fmt.Println(chordNotation("Cx", piano.noteKeyMap, 'R', 0))
+	fmt.Println(transposeChordSymbol("<Am/G:open>'", 3), transposeChordSymbol("<F#m7>", -1))
+
+	// root moved past C or by an octave plays in other octave
+	fmt.Println(transposeChordSymbol("<C:+1>", -1), transposeChordSymbol("<G7:up>", 12), transposeChordSymbol("<D>", -26))
+	fmt.Println(chordNotation("B:+1", piano.noteKeyMap, 'R', 0))
+	fmt.Println(chordNotation("Am", piano.noteKeyMap, 'R', 0))
+	fmt.Println(chordNotation("Cm:+1", piano.noteKeyMap, 'R', 0))
+
+	// Output:
+	// C C3qet <nil>
+	// Cmaj7 C4qetu <nil>
+	// Am/G C4tyip <nil>
+	// G7/1 C4uo[] <nil>
+	// Dm7b5 C4wr6i <nil>
+	// Csus4:open C3qt[ <nil>
+	// F:up ryi <nil>
+	// C3c'bm <nil>
+	//  chord Cx has unknown quality x
+	// <Cm/Bb:open:+1>' <Fm7>
+	// <B> <G7:up:+1> <C:-2>
+	// C3xgj <nil>
+	// C3yip <nil>
+	// C3i0] <nil>
+}
+
+func Example_chordSymbolEnd() {
+	for _, text := range []string{"<Am/G>'q", "<Am q", "<C"} {
+		fmt.Println(chordSymbolEnd([]rune(text), 0))
+	}
+	// notes after unclosed chord symbol are played
+	printMidiEvents("HR<Am qw")
+
+	// Output:
+	// 5 true
+	// 2 false
+	// 1 false
+	// 0 0 FF 51 03 07 CB 77
+	// 0 0 90 3C 7F
+	// 0 480 80 3C 00
+	// 0 480 90 3E 7F
+	// 0 960 80 3E 00
+	// 0 960 FF 2F 00
+}
@@ -6,9 +6,11 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -32,16 +34,33 @@ var (
 	flagBell      = flag.Bool("b", false, "send bell to PC speaker")
 	flagQuiet     = flag.Bool("q", false, "quiet stdout while playing music")
 	flagNotes     = flag.Bool("n", false, "print notes while playing music")
-	flagOutput    = flag.String("o", "", "output music waveform to file. Use '-' for stdout")
+	flagOutput    = flag.String("o", "", "output music waveform to WAV file or notes to MIDI file (.mid). Use '-' for stdout")
 	flagWeb       = flag.Bool("w", false, "start beep web server")
 	flagWebIP     = flag.String("a", "127.0.0.1:4444", "web server address")
 	flagVoiceDl   = flag.Bool("vd", false, "download voice files, by default downloads all voices")
+	flagVoiceURL  = flag.String("voice-url", "", "voice file download location, HTTP URL or file:// directory")
+	flagVoices    = flag.Bool("voices", false, "list installed voice files")
+	flagCache     = flag.Bool("voice-cache", false, "cache decoded voice samples")
+	flagUnchecked = flag.Bool("voice-unverified", false, "install downloaded voice files without a checksum in the SHA256SUMS index")
+	flagVoiceDir  = flag.String("voice-build", "", "build voice file from a directory of WAV recordings, use -o for output file")
 	flagMidiPlay  = flag.String("mp", "", "play MIDI file")
 	flagMidiURL   = flag.String("mu", "", "play MIDI from URL")
 	flagMidiNote  = flag.String("mn", "", "parses MIDI file and print notes")
 	flagPlayNotes = flag.String("play", "", "play notes from command argument")
+	flagTranspose = flag.Int("transpose", 0, "print music sheets transposed by semitones, for example -transpose +3 sheet.txt")
+	flagFormat    = flag.Bool("fmt", false, "print formatted music sheets, beep -fmt [-w] [-d] sheet.txt")
 	flagPlayURL   = flag.String("url", "", "play notes from URL")
 	flagBattery   = flag.Bool("battery", false, "monitor battery and alert low charge level")
+	flagSfz       = flag.String("sfz", "", "load SFZ instrument for the VS voice control")
+	flagSf2       = flag.String("sf2", "", "load SoundFont for the VG voice control and MIDI playback")
+	flagReverb    = flag.Int("reverb", 0, "master reverb level (0-9)")
+	flagDelay     = flag.Int("delay", 0, "master feedback delay level (0-9)")
+	flagChorus    = flag.Int("chorus", 0, "master chorus level (0-9)")
+	flagLoudness  = flag.Float64("lufs", 0, "normalize output file loudness to LUFS with true-peak limiting (e.g. -16), 0 is off")
+	flagA4        = flag.Float64("a4", 0, "concert pitch of A4 in Hertz (e.g. 415, 432, 442), default 440")
+	flagTuning    = flag.String("tuning", "", "tuning: equal, just, pythagorean, meantone, werckmeister, vallotti or Scala .scl file")
+	flagKbm       = flag.String("kbm", "", "Scala keyboard mapping .kbm file for the -tuning scale")
+	flagEQ        = flag.String("eq", "", "master equalizer bands [type]freq:gain[:Q],.. type is P, L or H (e.g. L100:3,H8000:-2)")
 
 	music *beep.Music
 )
@@ -58,6 +77,20 @@ Batbold Dashzeveg 2014-12-31`
 )
 
 func main() {
+	for i, arg := range os.Args[1:] {
+		if arg == "-fmt" || arg == "--fmt" {
+			// options after -fmt are its own, -w and -d before it are rejected
+			flag.CommandLine.Parse(os.Args[1 : i+1])
+			flag.Visit(func(f *flag.Flag) {
+				if f.Name == "w" || f.Name == "d" {
+					fmt.Fprintf(os.Stderr, "-%s must follow -fmt: beep -fmt [-w] [-d] sheet.txt\n", f.Name)
+					os.Exit(2)
+				}
+			})
+			formatCommand(os.Args[i+2:])
+			return
+		}
+	}
 	flag.Parse()
 
 	help := *flagHelp
@@ -79,6 +112,9 @@ func main() {
 	musicURL := *flagPlayURL
 
 	beep.PrintSheet = !*flagQuiet
+	beep.VoiceURL = *flagVoiceURL
+	beep.VoiceCache = *flagCache
+	beep.VoiceUnverified = *flagUnchecked
 	beep.PrintNotes = *flagNotes
 
 	if help {
@@ -91,6 +127,21 @@ func main() {
 		)
 		return
 	}
+	if *flagVoices {
+		beep.ListVoices(os.Stdout)
+		return
+	}
+	if len(*flagVoiceDir) > 0 {
+		output := *flagOutput
+		if len(output) == 0 {
+			output = filepath.Base(filepath.Clean(*flagVoiceDir)) + ".zip"
+		}
+		if err := beep.BuildVoice(*flagVoiceDir, output, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to build voice file:", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if printDemoSheet > 0 {
 		for i, sheet := range beep.BuiltinMusic {
 			if printDemoSheet == i+1 {
@@ -101,6 +152,18 @@ func main() {
 		fmt.Fprintf(os.Stderr, fmt.Sprintf("Demo music sheet must be 1-%d.\n", len(beep.BuiltinMusic)))
 		os.Exit(1)
 	}
+	transpose := false
+	flag.Visit(func(f *flag.Flag) {
+		transpose = transpose || f.Name == "transpose"
+	})
+	if *flagFormat {
+		formatCommand(flag.Args())
+		return
+	}
+	if transpose {
+		transposeSheets(*flagTranspose)
+		return
+	}
 	if volume < 1 || volume > 100 {
 		volume = 100
 	}
@@ -115,6 +178,72 @@ func main() {
 
 	music = beep.NewMusic(*flagOutput)
 
+	if len(*flagSfz) > 0 {
+		if err := music.LoadSfzVoice(*flagSfz); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to load SFZ instrument:", err)
+			os.Exit(1)
+		}
+	}
+	if len(*flagSf2) > 0 {
+		if err := music.LoadSoundFontVoices(*flagSf2); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to load SoundFont:", err)
+			os.Exit(1)
+		}
+	}
+
+	effects := beep.Effects{
+		Reverb: *flagReverb,
+		Delay:  *flagDelay,
+		Chorus: *flagChorus,
+	}
+	if effects.Reverb < 0 || effects.Reverb > 9 || effects.Delay < 0 || effects.Delay > 9 ||
+		effects.Chorus < 0 || effects.Chorus > 9 {
+		fmt.Fprintln(os.Stderr, "Effect levels must be 0-9.")
+		os.Exit(1)
+	}
+	if len(*flagEQ) > 0 {
+		bands, err := beep.ParseEQ(*flagEQ)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		effects.EQ = bands
+	}
+	music.SetEffects(effects)
+	if *flagLoudness > 0 {
+		fmt.Fprintln(os.Stderr, "Loudness target must be negative LUFS.")
+		os.Exit(1)
+	}
+	music.SetLoudness(*flagLoudness)
+
+	if len(*flagTuning) > 0 || len(*flagKbm) > 0 || *flagA4 != 0 {
+		if *flagA4 < 0 || *flagA4 > beep.SampleRate64/2 {
+			fmt.Fprintln(os.Stderr, "Invalid A4 frequency.")
+			os.Exit(1)
+		}
+		var tuning *beep.Tuning
+		var err error
+		if strings.HasSuffix(*flagTuning, ".scl") {
+			tuning, err = beep.LoadScala(*flagTuning, *flagKbm)
+			if err == nil && *flagA4 > 0 {
+				tuning.SetReference(*flagA4)
+			}
+		} else if len(*flagKbm) > 0 {
+			err = fmt.Errorf("keyboard mapping needs a Scala .scl tuning file")
+		} else {
+			name := *flagTuning
+			if len(name) == 0 {
+				name = "equal"
+			}
+			tuning, err = beep.NewTuning(name, *flagA4)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to load tuning:", err)
+			os.Exit(1)
+		}
+		music.SetTuning(tuning)
+	}
+
 	if err := beep.OpenSoundDevice(device); err != nil {
 		fmt.Println("failed to open sound device:", err)
 		os.Exit(1)
@@ -146,14 +275,7 @@ func main() {
 	}
 
 	if downloadVoices {
-		var names []string
-		for i, arg := range os.Args {
-			if i == 0 || strings.HasPrefix(arg, "-") {
-				continue
-			}
-			names = append(names, arg)
-		}
-		beep.DownloadVoiceFiles(music, os.Stdout, names)
+		beep.DownloadVoiceFiles(music, os.Stdout, flag.Args())
 		return
 	}
 
@@ -276,6 +398,7 @@ func playMusicNotesFromURL(music *beep.Music, urlpath string, volume int) {
 
 func playMusicScore(music *beep.Music, volume int) {
 	var files []io.Reader
+	var dirs []string // directories of included sheets
 	for _, fname := range flag.Args() {
 		if fname == "demo" {
 			fname = "demo1"
@@ -284,6 +407,7 @@ func playMusicScore(music *beep.Music, volume int) {
 			if fname == fmt.Sprintf("demo%d", i+1) {
 				demo := bytes.NewBuffer([]byte(sheet.Notation))
 				files = append(files, demo)
+				dirs = append(dirs, "")
 				fname = ""
 			}
 		}
@@ -296,9 +420,11 @@ func playMusicScore(music *beep.Music, volume int) {
 			os.Exit(1)
 		}
 		files = append(files, file)
+		dirs = append(dirs, filepath.Dir(fname))
 	}
 	if len(files) == 0 {
 		files = append(files, os.Stdin)
+		dirs = append(dirs, "")
 	}
 	for i, file := range files {
 		reader := bufio.NewReader(file)
@@ -307,6 +433,7 @@ func playMusicScore(music *beep.Music, volume int) {
 			time.Sleep(time.Second)
 		}
 		beep.InitSoundDevice()
+		music.SetSheetDir(dirs[i])
 		go music.Play(reader, volume)
 		music.Wait()
 		beep.FlushSoundBuffer()
@@ -320,6 +447,117 @@ func playMusicScore(music *beep.Music, volume int) {
 	}
 }
 
+func transposeSheets(semitones int) {
+	if semitones < -87 || semitones > 87 {
+		fmt.Fprintln(os.Stderr, "Transposition must be -87 to +87 semitones.")
+		os.Exit(1)
+	}
+	names := flag.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	for _, fname := range names {
+		sheet, dir, err := readSheet(fname)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		transposed, err := beep.TransposeSheet(string(sheet), semitones)
+		if err == nil {
+			// macros are transposed when played
+			_, err = beep.ParseScore(bufio.NewReader(strings.NewReader(transposed)), dir)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fname, err)
+			os.Exit(1)
+		}
+		fmt.Print(transposed)
+	}
+}
+
+// Parses options of -fmt, which has its own flags, and formats sheets
+func formatCommand(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write formatted sheet to its file")
+	changes := flags.Bool("d", false, "print lines changed by formatting")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: beep -fmt [-w] [-d] [sheet ...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	formatSheets(flags.Args(), *write, *changes)
+}
+
+func formatSheets(names []string, write, changes bool) {
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	for _, fname := range names {
+		sheet, _, err := readSheet(fname)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		formatted := beep.FormatSheet(string(sheet))
+		if changes {
+			printChanges(fname, string(sheet), formatted)
+		}
+		if !write {
+			if !changes {
+				fmt.Print(formatted)
+			}
+			continue
+		}
+		info, err := os.Stat(fname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: can not write formatted sheet\n", fname)
+			os.Exit(1)
+		}
+		if formatted == string(sheet) {
+			continue
+		}
+		if err := ioutil.WriteFile(fname, []byte(formatted), info.Mode()); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// Reads music sheet from file, built-in demo like demo2 or stdin if name
+// is "-", returns directory of included sheets
+func readSheet(fname string) ([]byte, string, error) {
+	if fname == "demo" {
+		fname = "demo1"
+	}
+	for i, demo := range beep.BuiltinMusic {
+		if fname == fmt.Sprintf("demo%d", i+1) {
+			return []byte(demo.Notation), "", nil
+		}
+	}
+	if fname == "-" {
+		sheet, err := ioutil.ReadAll(os.Stdin)
+		return sheet, "", err
+	}
+	sheet, err := ioutil.ReadFile(fname)
+	return sheet, filepath.Dir(fname), err
+}
+
+// Prints lines of sheet changed by formatting with their line numbers.
+// Formatting keeps lines in place and only removes blank lines at the end.
+func printChanges(fname, sheet, formatted string) {
+	lines := strings.Split(strings.TrimSuffix(sheet, "\n"), "\n")
+	formattedLines := strings.Split(strings.TrimSuffix(formatted, "\n"), "\n")
+	for i, line := range lines {
+		if i < len(formattedLines) && line == formattedLines[i] {
+			continue
+		}
+		fmt.Printf("%s:%d\n-%s\n", fname, i+1, line)
+		if i < len(formattedLines) {
+			fmt.Printf("+%s\n", formattedLines[i])
+		}
+	}
+}
+
 func playBeep(music *beep.Music, volume, duration, count int, freq float64) {
 	bar := beep.SampleAmp16bit * (float64(volume) / 100.0)
 	samples := int(beep.SampleRate64 * (float64(duration) / 1000.0))
@@ -0,0 +1,307 @@
+package beep
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultVoiceURL is the voice file download location
+const DefaultVoiceURL = "http://bmrust.com/dl/beep/voices/"
+
+// VoiceURL overrides voice file download location. It can be an HTTP URL
+// or a file:// directory. If empty, BEEP_VOICE_URL environment variable,
+// 'voice-url' in the config file or DefaultVoiceURL is used.
+var VoiceURL string
+
+// VoiceUnverified allows installing downloaded voice files that can't be
+// verified by the checksum index, also enabled by 'voice-unverified = true'
+// in the config file
+var VoiceUnverified bool
+
+// Name of the checksum index published with voice files,
+// in the format of sha256sum output
+const voiceIndexName = "SHA256SUMS"
+
+// Returns voice file download location
+func voiceBaseURL() string {
+	base := VoiceURL
+	if len(base) == 0 {
+		base = os.Getenv("BEEP_VOICE_URL")
+	}
+	if len(base) == 0 {
+		base = readConfig()["voice-url"]
+	}
+	if len(base) == 0 {
+		base = DefaultVoiceURL
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base
+}
+
+// Reads 'name = value' lines of the beep config file
+func readConfig() map[string]string {
+	config := make(map[string]string)
+	file, err := os.Open(filepath.Join(HomeDir(), "config"))
+	if err != nil {
+		return config
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if i := strings.Index(line, "="); i > 0 {
+			config[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return config
+}
+
+// DownloadVoiceFiles downloads natural voice files. Voice files are
+// verified by the checksum index published with them, files missing from
+// the index are not installed unless VoiceUnverified is set.
+func DownloadVoiceFiles(music *Music, writer io.Writer, names []string) {
+	dir := filepath.Join(HomeDir(), "voices")
+	if len(names) == 0 {
+		names = []string{"piano", "violin"}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintln(writer, "Error creating voice directory:", err)
+		return
+	}
+	// close voice files being replaced, voices are loaded again when played
+	for _, name := range names {
+		switch strings.TrimSuffix(name, ".zip") {
+		case "piano":
+			if music.piano != nil {
+				music.piano.natural.Close()
+				music.piano = nil
+			}
+		case "violin":
+			if music.violin != nil {
+				music.violin.natural.Close()
+				music.violin = nil
+			}
+		}
+	}
+	unverified := VoiceUnverified || readConfig()["voice-unverified"] == "true"
+	downloadVoices(writer, voiceBaseURL(), dir, names, unverified)
+}
+
+// Downloads voice files from base location into dir, files not in the
+// checksum index are only installed if unverified is true
+func downloadVoices(writer io.Writer, base, dir string, names []string, unverified bool) {
+	index, err := fetchVoiceIndex(base)
+	if err != nil {
+		if !unverified {
+			fmt.Fprintf(writer, "Error: no checksum index at %s%s: %v\n", base, voiceIndexName, err)
+			return
+		}
+		fmt.Fprintf(writer, "Warning: no checksum index at %s%s: %v\n", base, voiceIndexName, err)
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".zip") {
+			name += ".zip"
+		}
+		checksum, found := index[name]
+		if !found && !unverified {
+			fmt.Fprintf(writer, "Error: %s is not in checksum index\n", name)
+			continue
+		}
+		filename := filepath.Join(dir, name)
+		err := downloadVoiceFile(writer, base+name, filename, checksum)
+		if err != nil {
+			fmt.Fprintln(writer, " Error:", err)
+			continue
+		}
+		fmt.Fprintf(writer, "  Saving %s\n", filename)
+	}
+}
+
+// Opens a voice location for reading from offset. Returns reader,
+// total size if known, and whether the reader starts at offset.
+func openVoiceURL(location string, offset int64) (io.ReadCloser, int64, bool, error) {
+	if strings.HasPrefix(location, "file://") {
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		file, err := os.Open(fileURLPath(u))
+		if err != nil {
+			return nil, 0, false, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, 0, false, err
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, 0, false, err
+		}
+		return file, info.Size(), true, nil
+	}
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, resp.ContentLength, false, nil
+	case http.StatusPartialContent:
+		return resp.Body, offset + resp.ContentLength, true, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// partial file is already complete
+		resp.Body.Close()
+		return ioutil.NopCloser(strings.NewReader("")), offset, true, nil
+	}
+	resp.Body.Close()
+	return nil, 0, false, fmt.Errorf("status: %s", resp.Status)
+}
+
+// Returns local path of file URL, file:///C:/voices is C:\voices on Windows
+func fileURLPath(u *url.URL) string {
+	path := u.Path
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' &&
+		(path[1] >= 'A' && path[1] <= 'Z' || path[1] >= 'a' && path[1] <= 'z') {
+		path = path[1:] // drive letter
+	}
+	return filepath.FromSlash(path)
+}
+
+// Fetches checksum index of voice files, names map to SHA-256 checksums
+func fetchVoiceIndex(base string) (map[string]string, error) {
+	reader, _, _, err := openVoiceURL(base+voiceIndexName, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	index := make(map[string]string)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// binary mode file names start with '*'
+		index[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return index, scanner.Err()
+}
+
+// Downloads a voice file into a partial file, resuming a previous download,
+// verifies checksum and renames it to filename
+func downloadVoiceFile(writer io.Writer, location, filename, checksum string) error {
+	fmt.Fprintf(writer, "Downloading '%s'", location)
+	partname := filename + ".part"
+	var offset int64
+	if info, err := os.Stat(partname); err == nil {
+		offset = info.Size()
+	}
+	reader, size, resumed, err := openVoiceURL(location, offset)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resumed && offset > 0 {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		fmt.Fprintf(writer, " resuming at %s", numberComma(offset))
+		if size > 0 {
+			fmt.Fprintf(writer, " of %s bytes", numberComma(size))
+		}
+	} else if size > 0 {
+		offset = 0
+		fmt.Fprintf(writer, " %s bytes", numberComma(size))
+	} else {
+		offset = 0
+	}
+	fmt.Fprint(writer, " ...")
+	file, err := os.OpenFile(partname, flag, 0644)
+	if err != nil {
+		return err
+	}
+	progress := &downloadProgress{writer: writer, done: offset, size: size}
+	if size > 0 {
+		progress.step = offset * 10 / size
+	}
+	_, err = io.Copy(io.MultiWriter(file, progress), reader)
+	fmt.Fprintln(writer)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// keep partial file to resume
+		return err
+	}
+
+	if len(checksum) > 0 {
+		sum, err := fileChecksum(partname)
+		if err != nil {
+			return err
+		}
+		if sum != checksum {
+			os.Remove(partname)
+			return errors.New("SHA-256 checksum mismatch")
+		}
+	} else {
+		fmt.Fprintln(writer, "  Warning: file is not in checksum index")
+	}
+	return os.Rename(partname, filename)
+}
+
+// Returns SHA-256 checksum of a file
+func fileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Reports download progress in 10% steps
+type downloadProgress struct {
+	writer io.Writer
+	done   int64
+	size   int64
+	step   int64
+}
+
+func (p *downloadProgress) Write(buf []byte) (int, error) {
+	p.done += int64(len(buf))
+	if p.size > 0 {
+		step := p.done * 10 / p.size
+		for p.step < step {
+			p.step++
+			fmt.Fprintf(p.writer, " %d%%", p.step*10)
+		}
+	}
+	return len(buf), nil
+}
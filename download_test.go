@@ -0,0 +1,101 @@
+package beep
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func Example_fileURLPath() {
+	for _, location := range []string{"file:///C:/voices/piano.zip", "file:///home/beep/voices"} {
+		u, _ := url.Parse(location)
+		fmt.Println(filepath.ToSlash(fileURLPath(u)))
+	}
+
+	// Output:
+	// C:/voices/piano.zip
+	// /home/beep/voices
+}
+
+func Example_downloadVoiceFile() {
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+	voice := []byte("0123456789")
+	hash := sha256.Sum256(voice)
+	checksum := hex.EncodeToString(hash[:])
+	ioutil.WriteFile(filepath.Join(dir, "piano.zip"), voice, 0644)
+	location := "file://" + filepath.ToSlash(filepath.Join(dir, "piano.zip"))
+	filename := filepath.Join(dir, "voices", "piano.zip")
+	os.Mkdir(filepath.Dir(filename), 0755)
+
+	// resume a partial download
+	ioutil.WriteFile(filename+".part", voice[:4], 0644)
+	var out bytes.Buffer
+	err := downloadVoiceFile(&out, location, filename, checksum)
+	data, _ := ioutil.ReadFile(filename)
+	fmt.Print(strings.Replace(out.String(), location, "piano.zip", 1))
+	fmt.Println(err, string(data))
+
+	// a partial download of another file fails the checksum and is removed
+	ioutil.WriteFile(filename+".part", []byte("abcd"), 0644)
+	out.Reset()
+	err = downloadVoiceFile(&out, location, filename, checksum)
+	_, perr := os.Stat(filename + ".part")
+	fmt.Print(strings.Replace(out.String(), location, "piano.zip", 1))
+	fmt.Println(err, os.IsNotExist(perr))
+
+	// Output:
+	// Downloading 'piano.zip' resuming at 4 of 10 bytes ... 50% 60% 70% 80% 90% 100%
+	// <nil> 0123456789
+	// Downloading 'piano.zip' resuming at 4 of 10 bytes ... 50% 60% 70% 80% 90% 100%
+	// SHA-256 checksum mismatch true
+}
+
+func Example_downloadVoices() {
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+	mirror := filepath.Join(dir, "mirror")
+	voices := filepath.Join(dir, "voices")
+	os.Mkdir(mirror, 0755)
+	os.Mkdir(voices, 0755)
+	for _, name := range []string{"piano.zip", "violin.zip"} {
+		ioutil.WriteFile(filepath.Join(mirror, name), []byte(name), 0644)
+	}
+	base := "file://" + filepath.ToSlash(mirror) + "/"
+	var out bytes.Buffer
+	print := func() {
+		text := strings.Replace(out.String(), base, "mirror/", -1)
+		fmt.Print(strings.Replace(text, dir+string(filepath.Separator), "", -1))
+		out.Reset()
+	}
+
+	// nothing is installed without checksum index
+	downloadVoices(&out, base, voices, []string{"piano"}, false)
+	print()
+
+	// violin is not in checksum index
+	hash := sha256.Sum256([]byte("piano.zip"))
+	index := hex.EncodeToString(hash[:]) + "  piano.zip\n"
+	ioutil.WriteFile(filepath.Join(mirror, voiceIndexName), []byte(index), 0644)
+	downloadVoices(&out, base, voices, []string{"piano", "violin"}, false)
+	print()
+
+	// unless unverified voice files are allowed
+	downloadVoices(&out, base, voices, []string{"violin"}, true)
+	print()
+
+	// Output:
+	// Error: no checksum index at mirror/SHA256SUMS: open mirror/SHA256SUMS: no such file or directory
+	// Downloading 'mirror/piano.zip' 9 bytes ... 10% 20% 30% 40% 50% 60% 70% 80% 90% 100%
+	//   Saving voices/piano.zip
+	// Error: violin.zip is not in checksum index
+	// Downloading 'mirror/violin.zip' 10 bytes ... 10% 20% 30% 40% 50% 60% 70% 80% 90% 100%
+	//   Warning: file is not in checksum index
+	//   Saving voices/violin.zip
+}
@@ -0,0 +1,375 @@
+package beep
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Effects - effect settings of a line or master output, zero levels disable effects
+type Effects struct {
+	Reverb int      // reverb level 0-9
+	Delay  int      // feedback delay level 0-9
+	Chorus int      // chorus level 0-9
+	EQ     []EQBand // equalizer bands
+}
+
+// EQBand - parametric equalizer band
+type EQBand struct {
+	Type rune    // 'P' peak, 'L' low shelf, 'H' high shelf
+	Freq float64 // center or corner frequency in hertz
+	Gain float64 // dB
+	Q    float64
+}
+
+// Equalizer bands of the EB, EM and ET notation controls
+var notationEQ = []EQBand{
+	{Type: 'L', Freq: 200, Q: 0.707},
+	{Type: 'P', Freq: 1000, Q: 0.707},
+	{Type: 'H', Freq: 4000, Q: 0.707},
+}
+
+// ParseEQ parses equalizer bands like "L100:+3,1000:-2:1.4,H8000:2",
+// where each band is [type]frequency:gain[:Q]. Type is P (peak, default),
+// L (low shelf) or H (high shelf).
+func ParseEQ(spec string) ([]EQBand, error) {
+	var bands []EQBand
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+		band := EQBand{Type: 'P', Q: 0.707}
+		switch field[0] {
+		case 'P', 'L', 'H':
+			band.Type = rune(field[0])
+			field = field[1:]
+		}
+		parts := strings.Split(field, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid EQ band: %s", field)
+		}
+		values := make([]float64, len(parts))
+		for i, part := range parts {
+			value, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EQ band: %s", field)
+			}
+			values[i] = value
+		}
+		band.Freq, band.Gain = values[0], values[1]
+		if len(values) == 3 {
+			band.Q = values[2]
+		}
+		if band.Freq <= 0 || band.Freq >= SampleRate64/2 || band.Q <= 0 {
+			return nil, fmt.Errorf("invalid EQ band: %s", field)
+		}
+		bands = append(bands, band)
+	}
+	return bands, nil
+}
+
+// Chain of streaming effect processors. Processors keep their state
+// between buffers, so reverb and delay tails continue into the next line.
+type effectChain struct {
+	settings Effects
+	eq       []*biquad // filters of EQ bands, nil if band gain is 0
+	chorus   *chorus
+	delay    *feedbackDelay
+	reverb   *reverb
+}
+
+// Returns a new effect chain
+func newEffectChain(settings Effects) *effectChain {
+	c := &effectChain{}
+	c.set(settings)
+	return c
+}
+
+// Changes effect settings, running processors keep their state
+func (c *effectChain) set(settings Effects) {
+	c.settings = settings
+	if len(c.eq) != len(settings.EQ) {
+		c.eq = make([]*biquad, len(settings.EQ))
+	}
+	for i, band := range settings.EQ {
+		switch {
+		case band.Gain == 0:
+			c.eq[i] = nil
+		case c.eq[i] == nil:
+			c.eq[i] = newBiquad(band)
+		default:
+			c.eq[i].set(band)
+		}
+	}
+	if settings.Chorus > 0 {
+		if c.chorus == nil {
+			c.chorus = newChorus()
+		}
+		c.chorus.set(settings.Chorus)
+	}
+	if settings.Delay > 0 {
+		if c.delay == nil {
+			c.delay = newFeedbackDelay()
+		}
+		c.delay.set(settings.Delay)
+	}
+	if settings.Reverb > 0 {
+		if c.reverb == nil {
+			c.reverb = newReverb()
+		}
+		c.reverb.set(settings.Reverb)
+	}
+}
+
+// Returns true if any effect is enabled
+func (c *effectChain) active() bool {
+	s := c.settings
+	for _, filter := range c.eq {
+		if filter != nil {
+			return true
+		}
+	}
+	return s.Chorus > 0 || s.Delay > 0 || s.Reverb > 0
+}
+
+// Returns number of samples the chain keeps sounding after input ends
+func (c *effectChain) tailLength() int {
+	if c.settings.Reverb > 0 || c.settings.Delay > 0 {
+		return SampleRate * 2
+	}
+	return 0
+}
+
+// Processes buf in place
+func (c *effectChain) process(buf []int16) {
+	if !c.active() {
+		return
+	}
+	s := c.settings
+	for i, bar := range buf {
+		x := float64(bar)
+		for _, filter := range c.eq {
+			if filter != nil {
+				x = filter.process(x)
+			}
+		}
+		if s.Chorus > 0 {
+			x = c.chorus.process(x)
+		}
+		if s.Delay > 0 {
+			x = c.delay.process(x)
+		}
+		if s.Reverb > 0 {
+			x = c.reverb.process(x)
+		}
+		if x > SampleAmp16bit {
+			x = SampleAmp16bit
+		} else if x < -SampleAmp16bit {
+			x = -SampleAmp16bit
+		}
+		buf[i] = int16(x)
+	}
+}
+
+// Biquad filter, coefficients from the RBJ audio EQ cookbook
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func newBiquad(band EQBand) *biquad {
+	f := &biquad{}
+	f.set(band)
+	return f
+}
+
+// Sets filter coefficients of band, filter state is kept
+func (f *biquad) set(band EQBand) {
+	A := math.Pow(10, band.Gain/40)
+	w0 := 2 * math.Pi * band.Freq / SampleRate64
+	cosw := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * band.Q)
+	var b0, b1, b2, a0, a1, a2 float64
+	switch band.Type {
+	case 'L':
+		sq := 2 * math.Sqrt(A) * alpha
+		b0 = A * ((A + 1) - (A-1)*cosw + sq)
+		b1 = 2 * A * ((A - 1) - (A+1)*cosw)
+		b2 = A * ((A + 1) - (A-1)*cosw - sq)
+		a0 = (A + 1) + (A-1)*cosw + sq
+		a1 = -2 * ((A - 1) + (A+1)*cosw)
+		a2 = (A + 1) + (A-1)*cosw - sq
+	case 'H':
+		sq := 2 * math.Sqrt(A) * alpha
+		b0 = A * ((A + 1) + (A-1)*cosw + sq)
+		b1 = -2 * A * ((A - 1) + (A+1)*cosw)
+		b2 = A * ((A + 1) + (A-1)*cosw - sq)
+		a0 = (A + 1) - (A-1)*cosw + sq
+		a1 = 2 * ((A - 1) - (A+1)*cosw)
+		a2 = (A + 1) - (A-1)*cosw - sq
+	default:
+		b0 = 1 + alpha*A
+		b1 = -2 * cosw
+		b2 = 1 - alpha*A
+		a0 = 1 + alpha/A
+		a1 = -2 * cosw
+		a2 = 1 - alpha/A
+	}
+	f.b0, f.b1, f.b2 = b0/a0, b1/a0, b2/a0
+	f.a1, f.a2 = a1/a0, a2/a0
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// Chorus mixes the signal with a delay line modulated by a slow sine wave
+type chorus struct {
+	line  []float64
+	pos   int
+	phase float64
+	depth float64 // samples
+	mix   float64
+}
+
+const (
+	chorusDelay = SampleRate * 15 / 1000 // 15ms
+	chorusRate  = 0.8                    // hertz
+)
+
+func newChorus() *chorus {
+	return &chorus{
+		line: make([]float64, chorusDelay*2+2),
+	}
+}
+
+func (c *chorus) set(level int) {
+	c.depth = float64(level) / 9 * chorusDelay * 0.5
+	c.mix = float64(level) / 9 * 0.5
+}
+
+func (c *chorus) process(x float64) float64 {
+	size := len(c.line)
+	c.line[c.pos] = x
+	delay := chorusDelay + c.depth*math.Sin(c.phase)
+	c.phase += 2 * math.Pi * chorusRate / SampleRate64
+	if c.phase > 2*math.Pi {
+		c.phase -= 2 * math.Pi
+	}
+	// linear interpolation of the delay line
+	read := float64(c.pos) - delay
+	if read < 0 {
+		read += float64(size)
+	}
+	i := int(read)
+	frac := read - float64(i)
+	wet := c.line[i%size]*(1-frac) + c.line[(i+1)%size]*frac
+	c.pos = (c.pos + 1) % size
+	return x*(1-c.mix) + wet*c.mix
+}
+
+// Feedback delay repeats the signal every 250ms
+type feedbackDelay struct {
+	line     []float64
+	pos      int
+	feedback float64
+	mix      float64
+}
+
+func newFeedbackDelay() *feedbackDelay {
+	return &feedbackDelay{
+		line: make([]float64, SampleRate/4),
+	}
+}
+
+func (d *feedbackDelay) set(level int) {
+	d.feedback = float64(level) * 0.06
+	d.mix = float64(level) * 0.05
+}
+
+func (d *feedbackDelay) process(x float64) float64 {
+	wet := d.line[d.pos]
+	d.line[d.pos] = x + wet*d.feedback
+	d.pos = (d.pos + 1) % len(d.line)
+	return x + wet*d.mix
+}
+
+// Freeverb style reverb of parallel comb filters followed by allpass filters
+type reverb struct {
+	combs     []*combFilter
+	allpasses []*allpassFilter
+	wet       float64
+}
+
+var (
+	reverbCombTuning    = []int{1116, 1188, 1277, 1356, 1422, 1491, 1557, 1617}
+	reverbAllpassTuning = []int{556, 441, 341, 225}
+)
+
+func newReverb() *reverb {
+	r := &reverb{}
+	for _, size := range reverbCombTuning {
+		r.combs = append(r.combs, &combFilter{buf: make([]float64, size)})
+	}
+	for _, size := range reverbAllpassTuning {
+		r.allpasses = append(r.allpasses, &allpassFilter{buf: make([]float64, size)})
+	}
+	return r
+}
+
+func (r *reverb) set(level int) {
+	room := 0.7 + 0.28*float64(level)/9 // room size
+	for _, comb := range r.combs {
+		comb.feedback = room
+		comb.damp = 0.2
+	}
+	r.wet = float64(level) * 0.04
+}
+
+func (r *reverb) process(x float64) float64 {
+	input := x * 0.015 // fixed gain of freeverb
+	var out float64
+	for _, comb := range r.combs {
+		out += comb.process(input)
+	}
+	for _, allpass := range r.allpasses {
+		out = allpass.process(out)
+	}
+	return x + out*r.wet*3
+}
+
+// Lowpass feedback comb filter
+type combFilter struct {
+	buf      []float64
+	pos      int
+	feedback float64
+	damp     float64
+	store    float64
+}
+
+func (c *combFilter) process(x float64) float64 {
+	out := c.buf[c.pos]
+	c.store = out*(1-c.damp) + c.store*c.damp
+	c.buf[c.pos] = x + c.store*c.feedback
+	c.pos = (c.pos + 1) % len(c.buf)
+	return out
+}
+
+// Schroeder allpass filter
+type allpassFilter struct {
+	buf []float64
+	pos int
+}
+
+func (a *allpassFilter) process(x float64) float64 {
+	delayed := a.buf[a.pos]
+	out := delayed - x
+	a.buf[a.pos] = x + delayed*0.5
+	a.pos = (a.pos + 1) % len(a.buf)
+	return out
+}
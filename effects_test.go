@@ -0,0 +1,57 @@
+package beep
+
+import (
+	"fmt"
+	"math"
+)
+
+func ExampleParseEQ() {
+	bands, _ := ParseEQ("L100:+3,1000:-2:1.4,H8000:2")
+	for _, band := range bands {
+		fmt.Printf("%c %g Hz %+g dB Q %g\n", band.Type, band.Freq, band.Gain, band.Q)
+	}
+
+	// Output:
+	// L 100 Hz +3 dB Q 0.707
+	// P 1000 Hz -2 dB Q 1.4
+	// H 8000 Hz +2 dB Q 0.707
+}
+
+func Example_biquad() {
+	// 6 dB peak at 1 kHz
+	filter := newBiquad(EQBand{Type: 'P', Freq: 1000, Gain: 6, Q: 1})
+	for _, freq := range []float64{100, 1000, 10000} {
+		var peak float64
+		for i := 0; i < SampleRate; i++ {
+			y := filter.process(math.Sin(2 * math.Pi * freq * float64(i) / SampleRate64))
+			if i > SampleRate/2 {
+				peak = math.Max(peak, y)
+			}
+		}
+		fmt.Printf("%g Hz: %+.1f dB\n", freq, 20*math.Log10(peak))
+	}
+
+	// Output:
+	// 100 Hz: +0.1 dB
+	// 1000 Hz: +6.0 dB
+	// 10000 Hz: +0.0 dB
+}
+
+func Example_effectChain_set() {
+	// changing EQ gain keeps filter state, so the output doesn't click
+	chain := newEffectChain(Effects{EQ: []EQBand{{Type: 'L', Freq: 200, Gain: 6, Q: 0.707}}})
+	buf := make([]int16, 100)
+	for i := range buf {
+		buf[i] = 10000
+	}
+	chain.process(buf)
+	filter := chain.eq[0]
+	chain.set(Effects{EQ: []EQBand{{Type: 'L', Freq: 200, Gain: 3, Q: 0.707}}})
+	fmt.Println(chain.eq[0] == filter, filter.y1 != 0)
+	chain.set(Effects{EQ: []EQBand{{Type: 'L', Freq: 200, Q: 0.707}}})
+	fmt.Println(chain.eq[0] == nil, chain.active())
+
+	// Output:
+	// true true
+	// true false
+}
@@ -0,0 +1,185 @@
+package beep
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatSheet formats music sheet like gofmt formats Go code. Words of a
+// line are separated by one space and bars of lines joined by VN are aligned
+// by measure, words of a measure are aligned with words of the other lines.
+// Comments and blank lines are kept, macro definitions are only respaced.
+func FormatSheet(sheet string) string {
+	var (
+		out          []string
+		group        [][][]string // measures of words of lines joined by VN
+		groupAt      []int        // output lines of group lines
+		blockComment bool
+	)
+	flush := func() {
+		for i, line := range alignMeasures(group) {
+			out[groupAt[i]] = line
+		}
+		group, groupAt = nil, nil
+	}
+	sheet = strings.TrimRight(sheet, " \t\r\n")
+	if len(sheet) == 0 {
+		return ""
+	}
+	lines := strings.Split(sheet, "\n")
+	for _, line := range lines {
+		text := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(text, "#") || blockComment:
+			if strings.HasPrefix(text, "##") {
+				blockComment = !blockComment
+			}
+			out = append(out, strings.TrimRight(line, " \t\r"))
+			continue
+		case len(text) == 0:
+			flush()
+			out = append(out, "")
+			continue
+		}
+		if m, found := parseMacroDefinition(text, ""); found {
+			flush()
+			out = append(out, strings.TrimSpace("@"+m.name+" = "+strings.Join(sheetWords(m.text), " ")))
+			continue
+		}
+		var measures [][]string
+		for _, measure := range sheetMeasures(text) {
+			measures = append(measures, sheetWords(measure))
+		}
+		group = append(group, measures)
+		groupAt = append(groupAt, len(out))
+		out = append(out, "")
+		if !strings.HasSuffix(text, "VN") {
+			flush()
+		}
+	}
+	flush()
+	return strings.Join(out, "\n") + "\n"
+}
+
+// Splits sheet line into measures at bars, repeat marks |: and :| and
+// bars in directives and chord symbols are kept in measures. Hairpins
+// like !< and tempo changes like T>120 are not chord symbols.
+func sheetMeasures(line string) []string {
+	var measures []string
+	start, depth := 0, 0
+	for i := 0; i < len(line); i++ {
+		if line[i] == '<' || line[i] == '>' {
+			// T after D and R is a duration or rest like DT<C>
+			tempo := i > 0 && line[i-1] == 'T' && (i < 2 || line[i-2] != 'D' && line[i-2] != 'R')
+			if tempo || i > 0 && line[i-1] == '!' {
+				continue
+			}
+		}
+		switch line[i] {
+		case '{', '<':
+			depth++
+		case '}', '>':
+			if depth > 0 {
+				depth--
+			}
+		case '|':
+			repeat := i+1 < len(line) && line[i+1] == ':' || i > 0 && line[i-1] == ':'
+			if depth == 0 && !repeat {
+				measures = append(measures, line[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(measures, line[start:])
+}
+
+// Splits text into words at spaces, directives like {D.C. al Fine} are one word
+func sheetWords(text string) []string {
+	var words []string
+	word := ""
+	directive := false
+	for _, r := range text {
+		switch {
+		case r == '{':
+			directive = true
+		case r == '}':
+			directive = false
+		case (r == ' ' || r == '\t') && !directive:
+			if len(word) > 0 {
+				words = append(words, word)
+			}
+			word = ""
+			continue
+		}
+		word += string(r)
+	}
+	if len(word) > 0 {
+		words = append(words, word)
+	}
+	return words
+}
+
+// Returns lines of measures with words and bars aligned across lines
+func alignMeasures(lines [][][]string) []string {
+	texts := make([]string, len(lines))
+	for m := 0; ; m++ {
+		// widths of word columns of measure m
+		var widths []int
+		found := false
+		for _, measures := range lines {
+			if m >= len(measures) {
+				continue
+			}
+			found = true
+			for w, word := range measures[m] {
+				if w == len(widths) {
+					widths = append(widths, 0)
+				}
+				if n := utf8.RuneCountInString(word); n > widths[w] {
+					widths[w] = n
+				}
+			}
+		}
+		if !found {
+			break
+		}
+		width := 0
+		cells := make([]string, len(lines))
+		for i, measures := range lines {
+			if m >= len(measures) {
+				continue
+			}
+			words := measures[m]
+			for w, word := range words {
+				if w < len(words)-1 {
+					word = padRight(word, widths[w]) + " "
+				}
+				cells[i] += word
+			}
+			if n := utf8.RuneCountInString(cells[i]); n > width {
+				width = n
+			}
+		}
+		for i, measures := range lines {
+			switch {
+			case m >= len(measures):
+			case m == len(measures)-1:
+				texts[i] += cells[i]
+			default:
+				texts[i] += padRight(cells[i], width) + "|"
+			}
+		}
+	}
+	for i := range texts {
+		texts[i] = strings.TrimRight(texts[i], " ")
+	}
+	return texts
+}
+
+// Returns s padded with spaces to width runes
+func padRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
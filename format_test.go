@@ -0,0 +1,23 @@
+package beep
+
+import "fmt"
+
+func ExampleFormatSheet() {
+	sheet := `# melody
+@bass  =  HLDE z,HReq   yqeq
+|:  DE qw  er|t  | {D.C. al Fine}  VN
+HL   @bass|zx c,|HRq :|
+!p !<  qw| er !f|T<60  t|DT<C/E> |
+`
+	formatted := FormatSheet(sheet)
+	fmt.Print(formatted)
+	fmt.Println(FormatSheet(formatted) == formatted)
+
+	// Output:
+	// # melody
+	// @bass = HLDE z,HReq yqeq
+	// |: DE    qw er|t    |{D.C. al Fine} VN
+	// HL @bass      |zx c,|HRq            :|
+	// !p !< qw|er !f|T<60 t|DT<C/E>|
+	// true
+}
@@ -0,0 +1,216 @@
+package beep
+
+import (
+	"math"
+	"math/rand"
+)
+
+const (
+	truePeakCeiling    = -1.0 // dBTP
+	limiterLookahead   = 64   // samples
+	limiterRelease     = 0.05 // seconds
+	truePeakOversample = 4
+)
+
+// NormalizeLoudness normalizes interleaved 16-bit samples to the target
+// integrated loudness in LUFS, limits true peaks to -1 dBTP and adds TPDF
+// dither when the result is quantized back to 16-bit.
+func NormalizeLoudness(buf []int16, channels int, target float64) {
+	if len(buf) == 0 || channels < 1 {
+		return
+	}
+	loudness := IntegratedLoudness(buf, channels)
+	if math.IsInf(loudness, -1) {
+		return // silence
+	}
+	gain := math.Pow(10, (target-loudness)/20)
+
+	// planar float channels
+	frames := len(buf) / channels
+	planes := make([][]float64, channels)
+	for c := range planes {
+		plane := make([]float64, frames)
+		for i := range plane {
+			plane[i] = float64(buf[i*channels+c]) * gain
+		}
+		planes[c] = plane
+	}
+	limitTruePeak(planes, math.Pow(10, truePeakCeiling/20)*SampleAmp16bit)
+
+	// TPDF dither of 1 LSB
+	random := rand.New(rand.NewSource(1))
+	for c, plane := range planes {
+		for i, x := range plane {
+			x += random.Float64() - random.Float64()
+			x = math.Floor(x + 0.5)
+			if x > SampleAmp16bit {
+				x = SampleAmp16bit
+			} else if x < -SampleAmp16bit {
+				x = -SampleAmp16bit
+			}
+			buf[i*channels+c] = int16(x)
+		}
+	}
+}
+
+// IntegratedLoudness returns gated integrated loudness of interleaved
+// 16-bit samples in LUFS as specified by ITU-R BS.1770-4 and EBU R128
+func IntegratedLoudness(buf []int16, channels int) float64 {
+	frames := len(buf) / channels
+	block := SampleRate * 400 / 1000 // 400ms gating blocks
+	hop := block / 4                 // 75% overlap
+	if frames < block {
+		block = frames
+		hop = frames
+	}
+	if block == 0 {
+		return math.Inf(-1)
+	}
+
+	// mean square of K-weighted samples of every hop, summed over channels
+	hops := frames / hop
+	power := make([]float64, hops)
+	for c := 0; c < channels; c++ {
+		shelf, highPass := kWeighting()
+		for i := 0; i < hops*hop; i++ {
+			x := float64(buf[i*channels+c]) / SampleAmp16bit
+			y := highPass.process(shelf.process(x))
+			power[i/hop] += y * y
+		}
+	}
+	var blocks []float64
+	perBlock := block / hop
+	for i := 0; i+perBlock <= hops; i++ {
+		var sum float64
+		for _, p := range power[i : i+perBlock] {
+			sum += p
+		}
+		blocks = append(blocks, sum/float64(perBlock*hop))
+	}
+
+	// absolute gate at -70 LUFS, relative gate 10 LU below ungated loudness
+	gated := gateBlocks(blocks, math.Pow(10, (-70+0.691)/10))
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	relative := meanPower(gated) * math.Pow(10, -10.0/10)
+	gated = gateBlocks(gated, relative)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	return -0.691 + 10*math.Log10(meanPower(gated))
+}
+
+// Returns blocks with power above threshold
+func gateBlocks(blocks []float64, threshold float64) []float64 {
+	var gated []float64
+	for _, p := range blocks {
+		if p > threshold {
+			gated = append(gated, p)
+		}
+	}
+	return gated
+}
+
+func meanPower(blocks []float64) float64 {
+	var sum float64
+	for _, p := range blocks {
+		sum += p
+	}
+	return sum / float64(len(blocks))
+}
+
+// Returns the two K-weighting filters of BS.1770
+func kWeighting() (*biquad, *biquad) {
+	shelf := newBiquad(EQBand{Type: 'H', Freq: 1500, Gain: 4, Q: 1 / math.Sqrt2})
+
+	// second order high-pass at 38 hertz
+	w0 := 2 * math.Pi * 38 / SampleRate64
+	alpha := math.Sin(w0) / (2 * 0.5)
+	cosw := math.Cos(w0)
+	a0 := 1 + alpha
+	highPass := &biquad{
+		b0: (1 + cosw) / 2 / a0,
+		b1: -(1 + cosw) / a0,
+		b2: (1 + cosw) / 2 / a0,
+		a1: -2 * cosw / a0,
+		a2: (1 - alpha) / a0,
+	}
+	return shelf, highPass
+}
+
+// Limits inter-sample peaks of channels to ceiling with a lookahead
+// limiter. All channels share the gain to keep the stereo image.
+func limitTruePeak(planes [][]float64, ceiling float64) {
+	frames := len(planes[0])
+
+	// gain needed at each sample
+	needed := make([]float64, frames)
+	for i := range needed {
+		needed[i] = 1
+	}
+	for _, plane := range planes {
+		for i := range plane {
+			if peak := truePeak(plane, i); peak > ceiling {
+				needed[i] = math.Min(needed[i], ceiling/peak)
+			}
+		}
+	}
+
+	// minimum over the lookahead window, then moving average over the
+	// same window, so gain reaches the needed level before each peak
+	minimum := slidingMinimum(needed, limiterLookahead)
+	release := 1 - math.Exp(-1/(limiterRelease*SampleRate64))
+	sum := float64(limiterLookahead) // unity gain before the first sample
+	env := 1.0
+	for i := 0; i < frames; i++ {
+		sum += minimum[i]
+		if i >= limiterLookahead {
+			sum -= minimum[i-limiterLookahead]
+		} else {
+			sum--
+		}
+		env += (1 - env) * release
+		if avg := sum / limiterLookahead; avg < env {
+			env = avg
+		}
+		for _, plane := range planes {
+			plane[i] *= env
+		}
+	}
+}
+
+// Returns the highest absolute value between sample i and i+1,
+// interpolated at 4x oversampling
+func truePeak(x []float64, i int) float64 {
+	peak := math.Abs(x[i])
+	for k := 1; k < truePeakOversample; k++ {
+		pos := float64(i) + float64(k)/truePeakOversample
+		var sum float64
+		for j := i - resampleTaps + 1; j <= i+resampleTaps; j++ {
+			if j < 0 || j >= len(x) {
+				continue
+			}
+			sum += x[j] * lanczos(pos-float64(j))
+		}
+		peak = math.Max(peak, math.Abs(sum))
+	}
+	return peak
+}
+
+// Returns minimum of values in window [i, i+size) for every i
+func slidingMinimum(values []float64, size int) []float64 {
+	result := make([]float64, len(values))
+	var window []int // indexes of increasing values
+	for i := len(values) - 1; i >= 0; i-- {
+		for len(window) > 0 && values[window[len(window)-1]] >= values[i] {
+			window = window[:len(window)-1]
+		}
+		window = append(window, i)
+		if window[0] >= i+size {
+			window = window[1:]
+		}
+		result[i] = values[window[0]]
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package beep
+
+import (
+	"fmt"
+	"math"
+)
+
+func ExampleIntegratedLoudness() {
+	// 1 kHz stereo sine at -20 dBFS
+	buf := make([]int16, SampleRate*2*3)
+	for i := 0; i < len(buf)/2; i++ {
+		bar := int16(SampleAmp16bit * 0.1 * math.Sin(2*math.Pi*1000*float64(i)/SampleRate64))
+		buf[i*2], buf[i*2+1] = bar, bar
+	}
+	fmt.Printf("%.1f LUFS\n", IntegratedLoudness(buf, 2))
+
+	// normalize a square wave to -6 LUFS, peaks stay below -1 dBFS
+	for i := 0; i < len(buf)/2; i++ {
+		bar := int16(4000)
+		if i/50%2 == 0 {
+			bar = -4000
+		}
+		buf[i*2], buf[i*2+1] = bar, bar
+	}
+	NormalizeLoudness(buf, 2, -6)
+	var peak int16
+	for _, bar := range buf {
+		if bar > peak {
+			peak = bar
+		}
+	}
+	fmt.Printf("%.1f LUFS, peak below -1 dBFS: %v\n", IntegratedLoudness(buf, 2), float64(peak) < SampleAmp16bit*0.892)
+
+	// Output:
+	// -20.0 LUFS
+	// -6.0 LUFS, peak below -1 dBFS: true
+}
@@ -0,0 +1,108 @@
+package beep
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Notation defined by a sheet line like "@bass = HLDE z,HReq yqeq"
+type macro struct {
+	name string
+	text string
+	at   string // sheet location of definition
+}
+
+// Parses macro definition line, returns false if line is not a definition
+func parseMacroDefinition(line, at string) (*macro, bool) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, false
+	}
+	name, n := macroName(line[1:])
+	text := strings.TrimSpace(line[1+n:])
+	if n == 0 || !strings.HasPrefix(text, "=") {
+		return nil, false
+	}
+	return &macro{
+		name: name,
+		text: strings.TrimSpace(text[1:]),
+		at:   at,
+	}, true
+}
+
+// Returns macro name at start of s and its length
+func macroName(s string) (string, int) {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			break
+		}
+		n += len(string(r))
+	}
+	return s[:n], n
+}
+
+// Expands macros used in line like @bass, @bass+2 transposed by semitones
+// or @bass*4 repeated 4 times. Hand is the octave control the line starts with,
+// expanding lists names of macros being expanded to detect cycles.
+func expandMacros(line string, macros map[string]*macro, hand rune, expanding []string) (string, error) {
+	var buf strings.Builder
+	for {
+		at := strings.Index(line, "@")
+		if at < 0 {
+			break
+		}
+		buf.WriteString(line[:at])
+		hand = notationHand(line[:at], hand)
+		name, n := macroName(line[at+1:])
+		if n == 0 {
+			return "", fmt.Errorf("missing macro name after @")
+		}
+		line = line[at+1+n:]
+		runes := []rune(line)
+		semitones, count := 0, 1
+		if len(runes) > 0 && (runes[0] == '+' || runes[0] == '-') {
+			cents, m := parseCents(runes)
+			if m < 2 {
+				return "", fmt.Errorf("invalid transposition of macro @%s", name)
+			}
+			semitones = int(cents)
+			runes = runes[m:]
+		}
+		if len(runes) > 0 && runes[0] == '*' {
+			var m int
+			count, m = parseNumber(runes[1:])
+			if m == 0 || count < 1 {
+				return "", fmt.Errorf("invalid repeat count of macro @%s", name)
+			}
+			runes = runes[m+1:]
+		}
+		line = string(runes)
+
+		m, found := macros[name]
+		if !found {
+			return "", fmt.Errorf("unknown macro @%s", name)
+		}
+		for _, expanded := range expanding {
+			if expanded == name {
+				return "", fmt.Errorf("macro @%s defined at %s uses itself", name, m.at)
+			}
+		}
+		text, err := expandMacros(m.text, macros, hand, append(expanding, name))
+		if err == nil {
+			text, err = transposeNotation(text, semitones, hand)
+		}
+		if err != nil {
+			return "", fmt.Errorf("macro @%s defined at %s: %v", name, m.at, err)
+		}
+		for i := 0; i < count; i++ {
+			if i > 0 {
+				buf.WriteString(" ")
+			}
+			buf.WriteString(text)
+		}
+		hand = notationHand(text, hand)
+	}
+	buf.WriteString(line)
+	return buf.String(), nil
+}
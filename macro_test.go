@@ -0,0 +1,29 @@
+package beep
+
+import (
+	"fmt"
+)
+
+func Example_expandMacros() {
+	macros := make(map[string]*macro)
+	for i, line := range []string{"@bass = HLDE z,HReq", "@up = @bass+12", "@loop = @loop"} {
+		m, _ := parseMacroDefinition(line, fmt.Sprintf("line %d", i+1))
+		macros[m.name] = m
+	}
+	for _, line := range []string{"@bass*2 |", "@up", "@bass-2 q", "@bass+60", "@loop", "@none"} {
+		expanded, err := expandMacros(line, macros, 'R', nil)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println(expanded)
+	}
+
+	// Output:
+	// HLDE z,HReq HLDE z,HReq |
+	// HLDE ,HRypi
+	// HLDE ]mHRwHLlHR q
+	// macro @bass defined at line 1: transposed out of range: note , at column 7, note e at column 10, note q at column 11
+	// macro @loop defined at line 3: macro @loop defined at line 3 uses itself
+	// unknown macro @none
+}
@@ -0,0 +1,220 @@
+package beep
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the manifest file in a voice file
+const voiceManifestName = "voice.json"
+
+// VoiceManifest - voice.json metadata of a voice file
+type VoiceManifest struct {
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Author  string            `json:"author,omitempty"`
+	License string            `json:"license,omitempty"`
+	Tuning  float64           `json:"tuning,omitempty"` // A4 frequency the samples are tuned to, 440 if not set
+	Format  VoiceFormat       `json:"format"`
+	Keys    map[string]string `json:"keys,omitempty"`  // sample file to note name like "C4" or "C4_v2"
+	Files   map[string]string `json:"files,omitempty"` // sample file to SHA-256 checksum
+}
+
+// VoiceFormat - sample format of voice file samples
+type VoiceFormat struct {
+	SampleRate    int `json:"sampleRate"`
+	BitsPerSample int `json:"bitsPerSample,omitempty"`
+	Channels      int `json:"channels,omitempty"`
+}
+
+// MIDI note ranges of instrument voices
+var voiceKeyRanges = map[string][2]int{
+	"piano":  {21, 108}, // A0 - C8
+	"violin": {55, 100}, // G3 - E7
+}
+
+// Validate checks manifest fields
+func (m *VoiceManifest) Validate() error {
+	if len(m.Name) == 0 {
+		return errors.New("voice manifest has no name")
+	}
+	if len(m.Version) == 0 {
+		return errors.New("voice manifest has no version")
+	}
+	if m.Tuning < 0 || (m.Tuning > 0 && (m.Tuning < 400 || m.Tuning > 480)) {
+		return fmt.Errorf("invalid voice tuning: %v", m.Tuning)
+	}
+	if m.Format.SampleRate != SampleRate {
+		return fmt.Errorf("unsupported voice sample rate: %d", m.Format.SampleRate)
+	}
+	for name, note := range m.Keys {
+		if _, _, ok := parseSampleName(note + ".wav"); !ok {
+			return fmt.Errorf("invalid note name of %s: %s", name, note)
+		}
+	}
+	for name, sum := range m.Files {
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid SHA-256 checksum of %s", name)
+		}
+	}
+	return nil
+}
+
+// Returns note name of a sample file from manifest key map or file name
+func (m *VoiceManifest) noteName(filename string) string {
+	if m != nil {
+		if note, found := m.Keys[filename]; found {
+			return note + ".wav"
+		}
+	}
+	return filepath.Base(filename)
+}
+
+// Checks sample file data against manifest checksum and format
+func (m *VoiceManifest) check(filename string, data []byte, wave *WaveData) error {
+	if m == nil {
+		return nil
+	}
+	if len(m.Files) > 0 {
+		sum, found := m.Files[filename]
+		if !found {
+			return errors.New("file is not in voice manifest")
+		}
+		hash := sha256.Sum256(data)
+		if !strings.EqualFold(sum, hex.EncodeToString(hash[:])) {
+			return errors.New("SHA-256 checksum mismatch")
+		}
+	}
+	if wave == nil {
+		return nil
+	}
+	if wave.SampleRate != m.Format.SampleRate ||
+		(m.Format.BitsPerSample > 0 && wave.BitsPerSample != m.Format.BitsPerSample) ||
+		(m.Format.Channels > 0 && wave.Channels != m.Format.Channels) {
+		return errors.New("sample format doesn't match voice manifest")
+	}
+	return nil
+}
+
+// Reads and validates manifest of an open voice file, returns nil if the file has none
+func readVoiceManifest(voiceFile *zip.Reader) (*VoiceManifest, error) {
+	for _, zfile := range voiceFile.File {
+		if zfile.Name != voiceManifestName {
+			continue
+		}
+		file, err := zfile.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		var manifest VoiceManifest
+		if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("invalid voice manifest: %v", err)
+		}
+		if err := manifest.Validate(); err != nil {
+			return nil, err
+		}
+		return &manifest, nil
+	}
+	return nil, nil
+}
+
+// ListVoices prints installed voice files with their versions and key coverage
+func ListVoices(writer io.Writer) {
+	dir := filepath.Join(HomeDir(), "voices")
+	filenames, _ := filepath.Glob(filepath.Join(dir, "*.zip"))
+	if len(filenames) == 0 {
+		fmt.Fprintln(writer, "No voice files installed in", dir)
+		return
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		listVoice(writer, filename)
+	}
+}
+
+// Prints voice file info
+func listVoice(writer io.Writer, filename string) {
+	name := strings.TrimSuffix(filepath.Base(filename), ".zip")
+	voiceFile, err := zip.OpenReader(filename)
+	if err != nil {
+		fmt.Fprintf(writer, "%-10s error: %v\n", name, err)
+		return
+	}
+	defer voiceFile.Close()
+	manifest, err := readVoiceManifest(&voiceFile.Reader)
+	if err != nil {
+		fmt.Fprintf(writer, "%-10s error: %v\n", name, err)
+		return
+	}
+	version := "-"
+	if manifest != nil {
+		version = manifest.Version
+	}
+
+	keys := make(map[int]bool)
+	layers := 0
+	var failed []string
+	for _, zfile := range voiceFile.File {
+		if !strings.HasSuffix(zfile.Name, ".wav") {
+			continue
+		}
+		file, err := zfile.Open()
+		if err != nil {
+			failed = append(failed, zfile.Name)
+			continue
+		}
+		data, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil || manifest.check(zfile.Name, data, nil) != nil {
+			failed = append(failed, zfile.Name)
+			continue
+		}
+		noteName, level, ok := parseSampleName(manifest.noteName(zfile.Name))
+		if !ok {
+			continue
+		}
+		if number, err := sfzKey(noteName); err == nil {
+			keys[number] = true
+		}
+		if level > layers {
+			layers = level
+		}
+	}
+
+	coverage := fmt.Sprintf("%d keys", len(keys))
+	if r, found := voiceKeyRanges[name]; found {
+		recorded := 0
+		for number := range keys {
+			if number >= r[0] && number <= r[1] {
+				recorded++
+			}
+		}
+		total := r[1] - r[0] + 1
+		coverage = fmt.Sprintf("%d/%d keys (%d%%)", recorded, total, recorded*100/total)
+	}
+	fmt.Fprintf(writer, "%-10s %-8s %-22s %d layers", name, version, coverage, layers)
+	if manifest != nil {
+		if len(manifest.Author) > 0 {
+			fmt.Fprintf(writer, "  by %s", manifest.Author)
+		}
+		if len(manifest.License) > 0 {
+			fmt.Fprintf(writer, " (%s)", manifest.License)
+		}
+	} else {
+		fmt.Fprint(writer, "  no manifest")
+	}
+	fmt.Fprintln(writer)
+	for _, name := range failed {
+		fmt.Fprintf(writer, "  %s: integrity check failed\n", name)
+	}
+}
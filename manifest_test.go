@@ -0,0 +1,76 @@
+package beep
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func ExampleVoiceManifest_Validate() {
+	for _, manifest := range []VoiceManifest{
+		{Name: "piano", Version: "1.0", Format: VoiceFormat{SampleRate: SampleRate}},
+		{Name: "piano", Format: VoiceFormat{SampleRate: SampleRate}},
+		{Name: "piano", Version: "1.0", Format: VoiceFormat{SampleRate: 48000}},
+		{Name: "piano", Version: "1.0", Format: VoiceFormat{SampleRate: SampleRate}, Tuning: 300},
+		{Name: "piano", Version: "1.0", Format: VoiceFormat{SampleRate: SampleRate}, Keys: map[string]string{"c.wav": "C4_x2"}},
+		{Name: "piano", Version: "1.0", Format: VoiceFormat{SampleRate: SampleRate}, Files: map[string]string{"c.wav": "00"}},
+	} {
+		fmt.Println(manifest.Validate())
+	}
+
+	// Output:
+	// <nil>
+	// voice manifest has no version
+	// unsupported voice sample rate: 48000
+	// invalid voice tuning: 300
+	// invalid note name of c.wav: C4_x2
+	// invalid SHA-256 checksum of c.wav
+}
+
+func Example_listVoice() {
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+	var wav bytes.Buffer
+	NewWaveHeader(1, SampleRate, 16, 200).WriteHeader(&wav)
+	wav.Write(int16ToByteBuf(make([]int16, 100)))
+	hash := sha256.Sum256(wav.Bytes())
+
+	// violin has a sample changed after its checksum was taken
+	for _, name := range []string{"piano", "violin"} {
+		manifest := VoiceManifest{
+			Name:    name,
+			Version: "1.1",
+			Author:  "beep",
+			Format:  VoiceFormat{SampleRate: SampleRate},
+			Keys:    map[string]string{"c.wav": "C4", "d.wav": "D4_v2"},
+			Files:   map[string]string{"c.wav": hex.EncodeToString(hash[:]), "d.wav": hex.EncodeToString(hash[:])},
+		}
+		var buf bytes.Buffer
+		voiceFile := zip.NewWriter(&buf)
+		f, _ := voiceFile.Create(voiceManifestName)
+		json.NewEncoder(f).Encode(manifest)
+		f, _ = voiceFile.Create("c.wav")
+		f.Write(wav.Bytes())
+		f, _ = voiceFile.Create("d.wav")
+		if name == "violin" {
+			f.Write(append(wav.Bytes()[:len(wav.Bytes())-1], 1))
+		} else {
+			f.Write(wav.Bytes())
+		}
+		voiceFile.Close()
+		filename := filepath.Join(dir, name+".zip")
+		ioutil.WriteFile(filename, buf.Bytes(), 0644)
+		listVoice(os.Stdout, filename)
+	}
+
+	// Output:
+	// piano      1.1      2/88 keys (2%)         2 layers  by beep
+	// violin     1.1      1/46 keys (2%)         1 layers  by beep
+	//   d.wav: integrity check failed
+}
@@ -66,6 +66,10 @@ type MidiEvent struct {
 	Start      int
 	Note       *Note // beep note
 	NoteNumber byte  // MIDI note number
+
+	voice   Voice // voice selected by program change
+	channel byte
+	ticks   int // ticks of note until note off, 0 if not ended
 }
 
 // CalcDuration calculates duration for ticks
@@ -88,6 +92,65 @@ func (m *MidiEvent) CalcDuration(duration int, tickDiv int) {
 	}
 }
 
+// Marks notes of track events with articulations inferred from their
+// velocity and length. A note louder by 20 than the recent notes of its
+// channel and the note after it is accented, a lasting change of dynamics
+// is not. A note sounding up to 60% of the time to next note of its channel
+// is staccato and a note held until next note is tenuto.
+func markMidiArticulations(events []*MidiEvent, tickDiv int) {
+	var (
+		average [16]int // running average of velocities of each channel
+		recent  [16]int // average before notes starting at start
+		start   [16]int // start of last note of each channel
+	)
+	for i, e := range events {
+		c, velocity := e.channel, e.Note.velocity
+		if velocity == 0 {
+			continue
+		}
+		if e.Start != start[c] {
+			// notes of a chord are compared with the same average
+			recent[c], start[c] = average[c], e.Start
+		}
+		if average[c] == 0 {
+			average[c] = velocity
+		}
+		average[c] = (average[c] + velocity) / 2
+		if recent[c] == 0 || velocity < recent[c]+20 {
+			continue
+		}
+		next := nextMidiNote(events[i+1:], e)
+		if next == nil || next.Note.velocity < recent[c]+20 {
+			e.Note.articulation = '^'
+		}
+	}
+	for i, e := range events {
+		if e.Note.velocity == 0 || e.ticks == 0 || e.Note.articulation != 0 {
+			continue
+		}
+		if next := nextMidiNote(events[i+1:], e); next != nil {
+			gap := next.Start - e.Start
+			if e.ticks*10 <= gap*6 {
+				// staccato note takes time until next note
+				e.Note.articulation = '\''
+				e.CalcDuration(gap, tickDiv)
+			} else if e.ticks >= gap {
+				e.Note.articulation = '-'
+			}
+		}
+	}
+}
+
+// Returns first note of events starting after note e on its channel
+func nextMidiNote(events []*MidiEvent, e *MidiEvent) *MidiEvent {
+	for _, next := range events {
+		if next.Note.velocity > 0 && next.channel == e.channel && next.Start > e.Start {
+			return next
+		}
+	}
+	return nil
+}
+
 // Map between MIDI note number and beep notation
 var midiNoteMap = map[byte]string{
 	21: "H0,", 22: "H0l", 23: "H0.",
@@ -103,6 +166,43 @@ var midiNoteMap = map[byte]string{
 	104: "H76", 105: "H7y", 106: "H77", 107: "H7u", 108: "H7i",
 }
 
+// Map between beep key and MIDI note number
+var keyMidiNoteMap = make(map[rune]byte)
+
+func init() {
+	for number, name := range midiNoteMap {
+		keyMidiNoteMap[handKeyLevel(rune(name[1]))+rune(name[2])] = number
+	}
+}
+
+// Returns hand of the key level (octave group)
+func keyLevelHand(level rune) rune {
+	switch level {
+	case 1000:
+		return '0'
+	case 2000:
+		return 'L'
+	case 4000:
+		return '7'
+	}
+	return 'R'
+}
+
+// Returns key level of the hand (octave group)
+func handKeyLevel(hand rune) rune {
+	switch hand {
+	case '0': // octave 0
+		return 1000
+	case 'L': // octave 1, 2, 3
+		return 2000
+	case 'R': // octave 4, 5, 6
+		return 3000
+	case '7', '8': // octave 7, 8
+		return 4000
+	}
+	return 0
+}
+
 var (
 	midiOctave       string
 	midiNoteCount    int
@@ -398,8 +498,6 @@ func (midi *Midi) playTracks() {
 func (midi *Midi) mixTracks(events []*MidiEvent) {
 	var bufsize int
 	//var count = len(events)
-	var voice Voice = midi.music.piano
-
 	sustain := &Sustain{
 		attack:  8,
 		decay:   4,
@@ -416,7 +514,18 @@ func (midi *Midi) mixTracks(events []*MidiEvent) {
 			}
 			event.Note.volume = int(float32(SampleAmp16bit) * (float32(event.Note.velocity) / 127))
 			event.Note.measure()
+			if event.Note.articulation == '\'' {
+				// staccato, sounds half of the note
+				event.Note.samples /= 2
+			}
+			var voice Voice = midi.music.piano
+			if event.voice != nil {
+				voice = event.voice
+			}
 			if voice.GetNote(event.Note, sustain) {
+				if event.Note.articulation == '^' {
+					applyAccent(event.Note.buf)
+				}
 				voice.SustainNote(event.Note, sustain)
 			} else {
 				fmt.Println("Invalid note:", event.Note.key)
@@ -453,6 +562,7 @@ func (midi *Midi) Play() {
 	}
 	if midi.music.piano == nil {
 		midi.music.piano = NewPiano()
+		midi.music.piano.setTuning(midi.music.tuning)
 	}
 	var (
 		tickDiv    = midi.TickDiv
@@ -466,6 +576,8 @@ func (midi *Midi) Play() {
 		velocity   byte
 		msgLength  int32
 		timer      int
+		channel    byte
+		voices     [16]Voice // voice of each channel
 	)
 
 	fmt.Println("TickDiv:", tickDiv)
@@ -495,6 +607,8 @@ func (midi *Midi) Play() {
 			if runningStatus {
 				statusByte = lastStatus
 				//fmt.Printf("runningStatus %02X\n", statusByte)
+			} else if statusByte < 0xF0 {
+				channel = chunk.Data[i] & 0x0F
 			}
 
 			switch statusByte {
@@ -514,6 +628,7 @@ func (midi *Midi) Play() {
 				if noteOnEvent != nil {
 					noteDuration := timer - noteOnEvent.Start
 					noteOnEvent.CalcDuration(noteDuration, tickDiv)
+					noteOnEvent.ticks = noteDuration
 					delete(midiNoteOnMap, noteNumber)
 				}
 				hand := noteName[1]
@@ -574,6 +689,7 @@ func (midi *Midi) Play() {
 					if noteOnEvent != nil {
 						noteDuration := timer - noteOnEvent.Start
 						noteOnEvent.CalcDuration(noteDuration, tickDiv)
+						noteOnEvent.ticks = noteDuration
 						delete(midiNoteOnMap, noteNumber)
 					}
 				}
@@ -602,7 +718,6 @@ func (midi *Midi) Play() {
 					tempo:     4,
 					velocity:  int(velocity),
 				}
-
 				delta := quarterNote / tickDiv * int(deltaTime)
 				event = &MidiEvent{
 					Type:       MidiEventTrack,
@@ -610,6 +725,12 @@ func (midi *Midi) Play() {
 					Start:      timer,
 					Note:       note,
 					NoteNumber: noteNumber,
+					voice:      voices[channel],
+					channel:    channel,
+				}
+				if event.voice == nil {
+					// General MIDI default program
+					event.voice = midi.music.programVoice(int(channel), 0)
 				}
 				//fmt.Printf("Note On: %d %s %s v=%d\n", deltaTime, noteName, string(event.Note.duration), velocity)
 				midiNoteOnMap[noteNumber] = event
@@ -625,7 +746,14 @@ func (midi *Midi) Play() {
 				//fmt.Printf("Control change: channel=%02X program=%02X\n", channel, program)
 
 			case 0xC0: // program change
-				i++
+				var program byte
+				if runningStatus {
+					program = chunk.Data[i]
+				} else {
+					program = chunk.Data[i+1]
+					i++
+				}
+				voices[channel] = midi.music.programVoice(int(channel), int(program))
 
 			case 0xD0: // channel pressure
 				i++
@@ -673,11 +801,18 @@ func (midi *Midi) Play() {
 		}
 
 		if events != nil {
+			markMidiArticulations(events, tickDiv)
 			midi.mixTracks(events)
 			events = nil
 		}
 	}
 
+	master := newEffectChain(midi.music.effects)
+	if master.active() && midi.OutputBuf != nil {
+		midi.OutputBuf = append(midi.OutputBuf, make([]int16, master.tailLength())...)
+		master.process(midi.OutputBuf)
+	}
+
 	if len(midi.music.output) == 0 {
 		midi.playTracks()
 		midi.music.WaitLine()
@@ -699,6 +834,9 @@ func (midi *Midi) Play() {
 			buf[i*2] = bar
 			buf[i*2+1] = bar
 		}
+		if midi.music.loudness != 0 {
+			NormalizeLoudness(buf, 2, midi.music.loudness)
+		}
 		buf16 := int16ToByteBuf(buf)
 		header := NewWaveHeader(2, SampleRate, 16, len(buf16))
 		_, err = header.WriteHeader(midi.OutputFile)
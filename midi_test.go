@@ -0,0 +1,60 @@
+package beep
+
+import (
+	"fmt"
+)
+
+func Example_markMidiArticulations() {
+	// short, held and louder quarter notes, the last note has no next note
+	var events []*MidiEvent
+	for i, length := range []int{200, 480, 400, 400} {
+		note := &Note{velocity: 64}
+		if i == 2 {
+			note.velocity = 90
+		}
+		event := &MidiEvent{Start: i * 480, Note: note, ticks: length}
+		event.CalcDuration(length, 480)
+		events = append(events, event)
+	}
+	markMidiArticulations(events, 480)
+	for _, event := range events {
+		fmt.Printf("%c %q\n", event.Note.duration, event.Note.articulation)
+	}
+
+	// Output:
+	// Q '\''
+	// Q '-'
+	// Q '^'
+	// Q '\x00'
+}
+
+func Example_markMidiArticulations_dynamics() {
+	// accent in piano, then forte from a chord on is not accented
+	var events []*MidiEvent
+	for i, velocity := range []int{40, 40, 80, 40, 40, 80, 80, 80, 80, 40} {
+		for c := 0; c < 2; c++ {
+			if c == 1 && i != 5 {
+				continue
+			}
+			event := &MidiEvent{Start: i * 480, Note: &Note{velocity: velocity}, ticks: 300}
+			events = append(events, event)
+		}
+	}
+	markMidiArticulations(events, 480)
+	for _, event := range events {
+		fmt.Printf("%d %q\n", event.Note.velocity, event.Note.articulation)
+	}
+
+	// Output:
+	// 40 '\x00'
+	// 40 '\x00'
+	// 80 '^'
+	// 40 '\x00'
+	// 40 '\x00'
+	// 80 '\x00'
+	// 80 '\x00'
+	// 80 '\x00'
+	// 80 '\x00'
+	// 80 '\x00'
+	// 40 '\x00'
+}
@@ -0,0 +1,190 @@
+package beep
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ticks per quarter note of exported MIDI files
+const midiExportTickDiv = 480
+
+// A note played by a music sheet, exported to a MIDI file
+type midiNote struct {
+	start    int // sample position
+	samples  int
+	number   int // MIDI note number
+	velocity int
+	channel  int
+}
+
+// Tempo of exported MIDI file from sample position
+type midiTempo struct {
+	start   int     // sample position
+	quarter float64 // samples of quarter note
+}
+
+// Time signature of exported MIDI file from sample position
+type midiMeter struct {
+	start int // sample position
+	beats int
+	unit  int // note value of beat
+}
+
+// Controller change of exported MIDI file at sample position
+type midiControl struct {
+	start      int // sample position
+	controller int
+	value      int
+	channel    int
+}
+
+// Returns true if output file name is a MIDI file
+func isMidiFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".mid" || ext == ".midi"
+}
+
+// Returns MIDI channel of part, parts after the 9th skip percussion channel 10
+func midiChannel(part int) int {
+	if part >= 9 {
+		part++
+	}
+	if part > 15 {
+		part = 15
+	}
+	return part
+}
+
+// Returns MIDI note of a played note on channel, false if key has no MIDI
+// note number
+func newMidiNote(note *Note, start, channel int) (midiNote, bool) {
+	number, found := keyMidiNoteMap[note.key]
+	if !found {
+		return midiNote{}, false
+	}
+	n := midiNote{
+		start:    start,
+		samples:  note.samples,
+		number:   int(number),
+		velocity: noteVelocity(note),
+		channel:  channel,
+	}
+	if note.articulation == '^' {
+		// accent
+		n.velocity += 24
+		if n.velocity > 127 {
+			n.velocity = 127
+		}
+	}
+	return n, true
+}
+
+// Returns ticks of sample position, a quarter note is one beat at tempos
+// sorted by start. Without tempos, a quarter note is at normal tempo T4.
+func sampleTicks(position int, tempos []midiTempo) int {
+	ticks := 0.0
+	start := 0
+	quarter := float64(quarterNote)
+	for _, t := range tempos {
+		if t.start >= position {
+			break
+		}
+		ticks += float64(t.start-start) / quarter
+		start, quarter = t.start, t.quarter
+	}
+	ticks += float64(position-start) / quarter
+	return int(ticks*midiExportTickDiv + 0.5)
+}
+
+// Writes notes as a single track Standard MIDI File with tempo changes,
+// time signatures and controller changes, each part on its own channel
+func writeMidiFile(writer io.Writer, notes []midiNote, tempos []midiTempo, meters []midiMeter, controls []midiControl) error {
+	type midiExportEvent struct {
+		tick int
+		data []byte
+	}
+	sort.SliceStable(tempos, func(i, j int) bool {
+		return tempos[i].start < tempos[j].start
+	})
+	var events []midiExportEvent
+	if len(tempos) == 0 || tempos[0].start > 0 {
+		tempos = append([]midiTempo{{0, quarterNote}}, tempos...)
+	}
+	for _, t := range tempos {
+		tempo := int(t.quarter*1000000/SampleRate64 + 0.5) // microseconds per quarter note
+		events = append(events, midiExportEvent{sampleTicks(t.start, tempos),
+			[]byte{MidiEventMeta, MidiEventTypeTempo, 3, byte(tempo >> 16), byte(tempo >> 8), byte(tempo)}})
+	}
+	for _, m := range meters {
+		unit := 0
+		for 1<<uint(unit) < m.unit {
+			unit++
+		}
+		events = append(events, midiExportEvent{sampleTicks(m.start, tempos),
+			[]byte{MidiEventMeta, MidiEventTypeTimeSig, 4, byte(m.beats), byte(unit), 24, 8}})
+	}
+	for _, c := range controls {
+		events = append(events, midiExportEvent{sampleTicks(c.start, tempos),
+			[]byte{0xB0 | byte(c.channel), byte(c.controller), byte(c.value)}})
+	}
+	for _, n := range notes {
+		on := sampleTicks(n.start, tempos)
+		off := sampleTicks(n.start+n.samples, tempos)
+		if off <= on {
+			off = on + 1
+		}
+		events = append(events,
+			midiExportEvent{on, []byte{0x90 | byte(n.channel), byte(n.number), byte(n.velocity)}},
+			midiExportEvent{off, []byte{0x80 | byte(n.channel), byte(n.number), 0}})
+	}
+	order := func(data []byte) int {
+		if data[0] == MidiEventMeta {
+			return 0
+		}
+		switch data[0] & 0xF0 {
+		case 0x80:
+			return 1 // note off before note on of a repeated note
+		case 0xB0:
+			return 2 // controller change applies to note on
+		}
+		return 3
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].tick == events[j].tick {
+			return order(events[i].data) < order(events[j].data)
+		}
+		return events[i].tick < events[j].tick
+	})
+
+	var track bytes.Buffer
+	tick := 0
+	for _, event := range events {
+		track.Write(variableLengthBytes(event.tick - tick))
+		track.Write(event.data)
+		tick = event.tick
+	}
+	track.Write([]byte{0, MidiEventMeta, MidiEventTypeEndOfTrack, 0})
+
+	var file bytes.Buffer
+	file.WriteString("MThd")
+	binary.Write(&file, binary.BigEndian, []uint32{6})
+	binary.Write(&file, binary.BigEndian, []uint16{0, 1, midiExportTickDiv}) // format 0, 1 track
+	file.WriteString("MTrk")
+	binary.Write(&file, binary.BigEndian, uint32(track.Len()))
+	file.Write(track.Bytes())
+	_, err := writer.Write(file.Bytes())
+	return err
+}
+
+// Encodes variable length value used in MIDI
+func variableLengthBytes(value int) []byte {
+	buf := []byte{byte(value & 0x7F)}
+	for value >>= 7; value > 0; value >>= 7 {
+		buf = append([]byte{byte(value&0x7F) | 0x80}, buf...)
+	}
+	return buf
+}
@@ -0,0 +1,148 @@
+package beep
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns events of MIDI file tracks as "track tick data" lines
+func midiFileEvents(data []byte) []string {
+	var events []string
+	track := 0
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data[4:8]))
+		chunk := data[8 : 8+size]
+		if string(data[:4]) == "MTrk" {
+			tick := 0
+			for len(chunk) > 0 {
+				delta, n := VariableLengthValue(chunk)
+				tick += int(delta)
+				chunk = chunk[n:]
+				length := 3
+				if chunk[0] == MidiEventMeta {
+					length = 3 + int(chunk[2])
+				}
+				events = append(events, fmt.Sprintf("%d %d % X", track, tick, chunk[:length]))
+				chunk = chunk[length:]
+			}
+			track++
+		}
+		data = data[8+size:]
+	}
+	return events
+}
+
+// Plays sheet to a MIDI file and prints its events
+func printMidiEvents(sheet string) {
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "sheet.mid")
+	music := NewMusic(filename)
+	music.quietMode = true
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull) // without message of written file
+	go music.Play(bufio.NewReader(strings.NewReader(sheet)), 100)
+	<-music.played
+	os.Stdout.Close()
+	os.Stdout = stdout
+	data, _ := ioutil.ReadFile(filename)
+	for _, event := range midiFileEvents(data) {
+		fmt.Println(event)
+	}
+}
+
+func Example_writeMidiFile() {
+	// quarter note at T4, then triplet eighth notes at 120 BPM
+	third := 22050 / 3
+	notes := []midiNote{
+		{start: 0, samples: quarterNote, number: 60, velocity: 64},
+		{start: quarterNote, samples: third, number: 62, velocity: 80},
+		{start: quarterNote + third, samples: third, number: 64, velocity: 80},
+	}
+	tempos := []midiTempo{{quarterNote, 22050}}
+	meters := []midiMeter{{0, 3, 4}}
+	controls := []midiControl{{quarterNote, 11, 100, 0}}
+	var buf bytes.Buffer
+	writeMidiFile(&buf, notes, tempos, meters, controls)
+	fmt.Printf("%q\n", buf.Bytes()[:14])
+	for _, event := range midiFileEvents(buf.Bytes()) {
+		fmt.Println(event)
+	}
+
+	// Output:
+	// "MThd\x00\x00\x00\x06\x00\x00\x00\x01\x01\xe0"
+	// 0 0 FF 51 03 07 CB 77
+	// 0 0 FF 58 04 03 02 18 08
+	// 0 0 90 3C 40
+	// 0 480 FF 51 03 07 A1 20
+	// 0 480 80 3C 00
+	// 0 480 B0 0B 64
+	// 0 480 90 3E 50
+	// 0 640 80 3E 00
+	// 0 640 90 40 50
+	// 0 800 80 40 00
+	// 0 800 FF 2F 00
+}
+
+func Example_tieOverLine() {
+	// quarter note tied over end of line, tie before a rest is ignored
+	printMidiEvents("HRqw_\nHRwwe_ RQ")
+
+	// Output:
+	// 0 0 FF 51 03 07 CB 77
+	// 0 0 90 3C 7F
+	// 0 480 80 3C 00
+	// 0 480 90 3E 7F
+	// 0 1440 80 3E 00
+	// 0 1440 90 3E 7F
+	// 0 1920 80 3E 00
+	// 0 1920 90 40 7F
+	// 0 2400 80 40 00
+	// 0 2400 FF 2F 00
+}
+
+func Example_slurLegato() {
+	// legato pedal holds slurred notes together
+	printMidiEvents("HR(qwe) r")
+
+	// Output:
+	// 0 0 FF 51 03 07 CB 77
+	// 0 0 B0 44 7F
+	// 0 0 90 3C 7F
+	// 0 480 80 3C 00
+	// 0 480 90 3E 7F
+	// 0 960 80 3E 00
+	// 0 960 90 40 7F
+	// 0 1440 80 40 00
+	// 0 1440 B0 44 00
+	// 0 1440 90 41 7F
+	// 0 1920 80 41 00
+	// 0 1920 FF 2F 00
+}
+
+func Example_partChannels() {
+	// crescendo of second part changes expression of its own channel
+	printMidiEvents("HRq VN\nHL!p !< q !f")
+
+	// Output:
+	// 0 0 FF 51 03 07 CB 77
+	// 0 0 B1 0B 30
+	// 0 0 90 3C 7F
+	// 0 0 91 18 71
+	// 0 60 B1 0B 3A
+	// 0 120 B1 0B 43
+	// 0 180 B1 0B 4D
+	// 0 240 B1 0B 57
+	// 0 300 B1 0B 61
+	// 0 360 B1 0B 6B
+	// 0 420 B1 0B 75
+	// 0 480 80 3C 00
+	// 0 480 81 18 00
+	// 0 480 FF 2F 00
+}
@@ -4,8 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // BeepNotation description
@@ -45,6 +50,13 @@ Beep notation:
  DI     - sixty-fourth note
  DD     - dotted note (adds half duration)
 
+ Tuplets:
+ U#:#   - play next notes in the time of other number of notes,
+          for example triplet "DE U3:2 qwe" takes time of two
+          eighth notes. Chords and rests count as one note.
+ U#     - tuplet with default time, U3 is 3:2, U5 is 5:4, U2 is 2:3
+          Tuplets end with the line.
+
  Octave:
  H0     - octave 0 keys
  HL     - octave 1, 2, 3 (left hand keys)
@@ -53,6 +65,29 @@ Beep notation:
 
  Tempo:
  T#     - where # is 0-9, default is 4 (1 unit speeds up/down by 4%)
+ T=###  - beats per minute of quarter notes, for example "T=120"
+ T>###  - accelerando to ### beats per minute over 4 beats, T<### is
+          ritardando. T>###:# changes tempo over # beats
+
+ Time signature:
+ M#/#   - beats per bar and note value of beat, for example "M3/4".
+          Bars that do not match are reported, MIDI files include
+          the time signature
+
+ Pitch:
+ ~+##   - after a note, raises or lowers the note by cents,
+          for example quarter tone above C is "q~+50"
+ P+##   - detune following notes by cents, for example "P-14",
+          P0 resets
+ /      - glide into next note from previous note pitch,
+          for example "q /t" slides from C to G
+
+ Ties and slurs:
+ _      - after a note, ties the note to next note of same pitch,
+          played as one note without attack, for example "DH q_ | q",
+          a tie at end of line continues in next line of the part
+ (...)  - slurred notes are played legato, for example "(qwer)",
+          exported to MIDI files with the legato pedal (CC68)
 
  Sustain:
  SA#    - attack level, where # is 0-9, default is 8
@@ -64,21 +99,122 @@ Beep notation:
  VD     - Computer generated default voice
  VP     - Piano voice
  VV     - Violin voice
+ VS     - SFZ instrument voice, loaded with -sfz option
+ VG###  - General MIDI program from SoundFont loaded with -sf2 option,
+          where ### is 000-127. For example violin is "VG040"
  VN     - If a line ends with 'VN', the next line will be
-          played harmony with the line.
+          played harmony with the line. Lines played together are
+          parts, each part keeps its own voice, octave, duration,
+          amplitude, sustain, chord and effects in following lines
+          played together. For example violin melody, piano right hand and
+          piano left hand are "VV qwer VN", "VP DH C3qet VN" and
+          "HL DW q". Every part plays from the tempo at start of the
+          lines, tempo changes of the first part continue after them
+
+ Effects:
+ ER#    - reverb level, where # is 0-9, 0 is off
+ ED#    - feedback delay level, 0-9
+ EC#    - chorus level, 0-9
+ EB#    - bass EQ, 0-9, 5 is flat, 1 unit is 3 dB
+ EM#    - middle EQ, 0-9, 5 is flat
+ ET#    - treble EQ, 0-9, 5 is flat
+ EX     - turn off all line effects
+ Effects apply to the whole line they are set on and
+ following lines.
 
  Chord:
  C#     - Play next # notes as a chord, where # is 2-9.
           For example C major chord is "C3qet"
+ <C>    - chord symbol, plays chord of root C-B with # or b and
+          quality m, dim, aug, sus2, sus4, 5, 6, 7, maj7, m7, 9, 11,
+          13, add9, b5, #5, b9, #9, #11 or b13, for example "<Cmaj7>"
+          or "<F#m7b5>". Root is in octave 3 with HL and octave 4
+          with HR
+ <C/E>  - slash bass played below the chord, "<C/1>" is first
+          inversion
+ <C:open>, <C:drop2>
+        - open and drop 2 voicing
+ <C:up>, <C:down>
+        - plays chord notes one by one, for example "DE <Am:up>"
+ <C:+1> - plays root octaves up or down from octave of hand,
+          added by -transpose when root moves past C
+
+ Arpeggiator:
+ XUE    - plays following chords as notes one by one in eighth
+          notes, up from lowest note. XD is down, XB is up and
+          down, XR is random. Step is a note duration W, H, Q, E,
+          S, T or I. Random steps of a chord are the same every
+          time its line is played
+ XUE2:50
+        - steps over 2 octaves, 1-4, and notes sound 50 percent of
+          step, default is 80. For example "DH XBS2 <Am>"
+ X0     - turns off arpeggiator
 
  Amplitude:
  A#     - Changes current amplitude, where # is 1-9, default is 9
 
+ Dynamics:
+ !pp, !p, !mp, !mf, !f, !ff
+        - sets amplitude 2, 3, 5, 6, 8 and 9, follow by a space
+          before a note, for example "!mp qwe"
+ !<     - crescendo to next dynamic marking of the line over the
+          notes between, !> is diminuendo, for example "!p !< qwer !f"
+ !<f:#  - crescendo to f over # beats, for example "!>pp:8"
+          Hairpins end with the line, so lines joined by VN swell
+          independently
+
+ Articulations:
+ '      - after a note, staccato, plays half of the note and rests,
+          for example "q' w'"
+ ^      - after a note, accent, plays attack louder
+ -      - after a note, tenuto, holds the note for its full length
+ ;      - after a note, fermata, holds the note twice as long
+ \      - before a note, grace note stealing time of a 32nd note
+          from the next note, for example "\w q"
+          Articulation of a chord is marked on its first note, for
+          example "C3q'et". Played MIDI files get staccato and tenuto
+          from note lengths and accents from notes louder than the
+          notes around them
+
  Measures:
  |      - bar, ignored
  ' '    - space, ignored
  Tab    - tab, ignored
 
+ Repeats and jumps:
+ |:     - at the start of a line, repeat begins, lines joined by VN
+          repeat together
+ :|     - at the end of a line, plays again from repeat begin,
+          until the pass of the highest ending
+ {1}    - 1st ending, played on first pass, {2} on second pass,
+          {1,3} on first and third pass
+ {segno}, {coda}, {fine}, {to coda}
+        - jump marks
+ {D.C.}, {D.S.}, {D.C. al Fine}, {D.S. al Fine}, {D.C. al Coda},
+ {D.S. al Coda}
+        - jump to beginning or segno after the line, repeats are
+          not taken again and the last ending is played
+ {section A}
+        - lines from the line to next section are section A
+ {play A B A}
+        - plays sections in order, sections of a sheet with play
+          marks are only played by play marks
+
+ Macros:
+ @name = notes
+        - defines macro of notes, for example "@bass = HLDE z,HReq yqeq",
+          names are letters, digits and '_'
+ @name  - plays notes of macro defined before the line
+ @name+# - plays macro transposed up # semitones, @name-# down
+ @name*# - plays macro # times, for example "@bass+2*4"
+
+ Include:
+ #include "path"
+        - plays lines and defines macros of another sheet in place,
+          path is relative to the sheet or to the sheets directory of
+          beep home directory. Built-in "jingles" defines @ding,
+          @notify, @success, @failure and @alert
+
  Comments:
  #      - a line comment
  ##     - start or end of a block comment
@@ -126,7 +262,15 @@ type Music struct {
 	linePlayed chan bool // for syncing lines
 	piano      *Piano
 	violin     *Violin
-	output     string // output file name
+	sfz        *Sampler   // SFZ instrument voice
+	soundFont  *SoundFont // General MIDI voices
+	effects    Effects    // master output effects
+	loudness   float64    // loudness target of output file in LUFS, 0 is off
+	tuning     *Tuning    // nil is equal temperament with A4 at 440 hertz
+	output     string     // output file name
+	position   ScoreLine  // sheet position of playing line
+	sheetDir   string     // directory of sheets included by played sheet
+	mutex      sync.Mutex // guards position
 }
 
 // Note data
@@ -137,9 +281,20 @@ type Note struct {
 	volume    int
 	amplitude int
 	tempo     int
+	bpm       float64 // beats per minute of quarter notes, 0 uses tempo
 	buf       []int16
 	velocity  int
 	samples   int
+	cents     float64 // pitch offset from key
+	glide     float64 // cents from previous note pitch, glides to note pitch
+	tuplet    *Tuplet // tuplet group of the note, nil if none
+	slurIn    bool    // slurred from previous note, no attack
+	slurOut   bool    // slurred into next note, no release
+	level     float64 // amplitude at note start, fractional in hairpins
+	swell     float64 // amplitude change over the note by a hairpin
+
+	// staccato '\'', accent '^', tenuto '-' or fermata ';', 0 if none
+	articulation rune
 }
 
 // Sustain params
@@ -158,6 +313,16 @@ type Chord struct {
 	buf    []int16
 }
 
+// Tuplet params, notes of the group are played in the time of span notes
+type Tuplet struct {
+	notes  int // notes in the group, 0 if no tuplet
+	span   int // normal notes the group takes time of
+	count  int // notes played in the group
+	played int // samples of the played notes without the tuplet
+	scaled int // samples of the played notes
+	last   int // samples of the last measured note without the tuplet
+}
+
 // Voice interface
 // GetNote: Gets a whole note for the key
 // SustainNote: Used for sustaining computer generated voice
@@ -184,6 +349,78 @@ func NewMusic(output string) *Music {
 	return music
 }
 
+// LoadSfzVoice loads an SFZ instrument used by the VS voice control
+func (m *Music) LoadSfzVoice(filename string) error {
+	sampler, err := LoadSfz(filename)
+	if err != nil {
+		return err
+	}
+	sampler.tuning = m.tuning
+	m.sfz = sampler
+	return nil
+}
+
+// LoadSoundFontVoices loads a SoundFont used by the VG voice control and MIDI playback
+func (m *Music) LoadSoundFontVoices(filename string) error {
+	font, err := LoadSoundFont(filename)
+	if err != nil {
+		return err
+	}
+	for _, preset := range font.Presets {
+		preset.Voice.tuning = m.tuning
+	}
+	m.soundFont = font
+	return nil
+}
+
+// SetEffects sets effects of the master output
+func (m *Music) SetEffects(effects Effects) {
+	m.effects = effects
+}
+
+// SetLoudness sets loudness target of output files in LUFS, 0 disables normalization
+func (m *Music) SetLoudness(lufs float64) {
+	m.loudness = lufs
+}
+
+// SetTuning tunes all voices to tuning, nil is equal temperament with A4 at 440 hertz
+func (m *Music) SetTuning(tuning *Tuning) {
+	m.tuning = tuning
+	if m.piano != nil {
+		m.piano.setTuning(tuning)
+	}
+	if m.violin != nil {
+		m.violin.setTuning(tuning)
+	}
+	if m.sfz != nil {
+		m.sfz.tuning = tuning
+	}
+	if m.soundFont != nil {
+		for _, preset := range m.soundFont.Presets {
+			preset.Voice.tuning = tuning
+		}
+	}
+}
+
+// Returns SoundFont voice of General MIDI program for channel 0-15,
+// nil if no SoundFont is loaded
+func (m *Music) programVoice(channel, program int) Voice {
+	if m.soundFont == nil {
+		return nil
+	}
+	bank := 0
+	if channel == 9 {
+		bank = 128 // percussion
+	}
+	if sampler := m.soundFont.Preset(bank, program); sampler != nil {
+		return sampler
+	}
+	if sampler := m.soundFont.Preset(bank, 0); sampler != nil {
+		return sampler
+	}
+	return m.soundFont.Presets[0].Voice
+}
+
 // Wait until sheet is played
 func (m *Music) Wait() {
 	<-m.played // wait until player is done
@@ -194,6 +431,25 @@ func (m *Music) WaitLine() {
 	<-m.linePlayed
 }
 
+// SetSheetDir sets directory of sheets included by played sheet
+func (m *Music) SetSheetDir(dir string) {
+	m.sheetDir = dir
+}
+
+// Position returns sheet position of the line being played
+func (m *Music) Position() ScoreLine {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.position
+}
+
+// Sets sheet position of the line starting to play
+func (m *Music) setPosition(line ScoreLine) {
+	m.mutex.Lock()
+	m.position = line
+	m.mutex.Unlock()
+}
+
 // Ratio returns sustain ratio
 func (s *Sustain) Ratio() float64 {
 	return float64(s.sustain) / 10.0
@@ -206,8 +462,211 @@ func (c *Chord) Reset() {
 	c.buf = nil
 }
 
+// Starts a tuplet group of notes played in the time of span notes
+func (t *Tuplet) set(notes, span int) {
+	t.Reset()
+	if notes > 0 && span > 0 {
+		t.notes = notes
+		t.span = span
+	}
+}
+
+// Returns samples of a note in the group. Rounding is carried over
+// to the next note, so the group takes exactly the time of span notes.
+func (t *Tuplet) measure(samples int) int {
+	if t.notes == 0 {
+		return samples
+	}
+	t.last = samples
+	return (t.played+samples)*t.span/t.notes - t.scaled
+}
+
+// Moves to the next note of the group, the group ends after its last note
+func (t *Tuplet) next() {
+	if t.notes == 0 {
+		return
+	}
+	t.played += t.last
+	t.scaled = t.played * t.span / t.notes
+	t.count++
+	if t.count == t.notes {
+		t.Reset()
+	}
+}
+
+// Reset tuplet
+func (t *Tuplet) Reset() {
+	*t = Tuplet{}
+}
+
+// Tempo params, beats per minute of quarter notes changing gradually
+// from start to target over span beats
+type Tempo struct {
+	bpm    float64 // 0 uses tempo level of T control
+	start  float64
+	target float64
+	span   float64 // beats of accelerando or ritardando, 0 if none
+	beats  float64 // beats played since change started
+}
+
+// Sets beats per minute, 0 uses tempo level
+func (t *Tempo) set(bpm float64) {
+	*t = Tempo{bpm: bpm}
+}
+
+// Changes tempo gradually from bpm to target over beats
+func (t *Tempo) change(bpm, target, beats float64) {
+	*t = Tempo{bpm: bpm, start: bpm, target: target, span: beats}
+}
+
+// Advances gradual tempo change by samples played
+func (t *Tempo) next(samples int) {
+	if t.span == 0 {
+		return
+	}
+	t.beats += float64(samples) * t.bpm / 60 / SampleRate64
+	if t.beats >= t.span {
+		t.set(t.target)
+		return
+	}
+	t.bpm = t.start + (t.target-t.start)*t.beats/t.span
+}
+
+// Dynamics params, amplitude changing gradually by a hairpin
+type Dynamics struct {
+	level  float64 // amplitude 0-9
+	start  float64
+	target float64
+	span   float64 // beats or notes of hairpin, 0 if none
+	notes  bool    // span counts notes and rests instead of beats
+	played float64 // beats or notes played since hairpin started
+}
+
+// Sets amplitude level
+func (d *Dynamics) set(level float64) {
+	*d = Dynamics{level: level}
+}
+
+// Changes amplitude gradually to target over span beats, or over span
+// notes and rests if notes is true
+func (d *Dynamics) change(target, span float64, notes bool) {
+	start := d.level
+	if start == 0 {
+		start = 9 // A0 plays at full amplitude
+	}
+	*d = Dynamics{level: start, start: start, target: target, span: span, notes: notes}
+}
+
+// Returns amplitude level after a note or rest of beats is played
+func (d *Dynamics) after(beats float64) float64 {
+	if d.span == 0 {
+		return d.level
+	}
+	played := d.played + beats
+	if d.notes {
+		played = d.played + 1
+	}
+	if played >= d.span {
+		return d.target
+	}
+	return d.start + (d.target-d.start)*played/d.span
+}
+
+// Advances hairpin by a note or rest of beats played
+func (d *Dynamics) next(beats float64) {
+	if d.span == 0 {
+		return
+	}
+	d.level = d.after(beats)
+	if d.notes {
+		d.played++
+	} else {
+		d.played += beats
+	}
+	if d.played >= d.span {
+		d.set(d.target)
+	}
+}
+
+// Arpeggiator params, chords are played as notes one by one
+type Arpeggio struct {
+	mode    rune // 'U' up, 'D' down, 'B' up and down, 'R' random, 0 is off
+	rate    rune // note duration of steps
+	octaves int  // octave range of steps
+	gate    int  // percent of step the note sounds
+}
+
+// Returns keys of a cycle of steps played from keys of chord notes
+func (a *Arpeggio) pattern(keys []rune) []rune {
+	var numbers []int
+	for _, key := range keys {
+		if number, found := keyMidiNoteMap[key]; found {
+			numbers = append(numbers, int(number))
+		}
+	}
+	sort.Ints(numbers)
+	var up []rune
+	for octave := 0; octave < a.octaves; octave++ {
+		for _, number := range numbers {
+			if name, found := midiNoteMap[byte(number+12*octave)]; found && number+12*octave < 128 {
+				up = append(up, handKeyLevel(rune(name[1]))+rune(name[2]))
+			}
+		}
+	}
+	switch a.mode {
+	case 'D':
+		for i, j := 0, len(up)-1; i < j; i, j = i+1, j-1 {
+			up[i], up[j] = up[j], up[i]
+		}
+	case 'B':
+		for i := len(up) - 2; i > 0; i-- {
+			up = append(up, up[i])
+		}
+	}
+	return up
+}
+
+// Returns random source of random arpeggio of the chord ending at column
+// of line. A chord plays the same steps every time its line is played and
+// chords of other lines or columns play other steps.
+func arpeggioRandom(line string, column int) *rand.Rand {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d %s", column, line)
+	return rand.New(rand.NewSource(int64(hash.Sum64())))
+}
+
+// Notation state of a part, lines joined by VN are parts of a system
+// and each part continues its own state in the next system. Tempo and
+// time signature are shared by parts.
+type Part struct {
+	voice       Voice
+	duration    rune
+	hand        rune
+	dynamics    Dynamics
+	sustain     Sustain
+	sustainType rune
+	chord       Chord
+	chordMark   rune
+	detune      float64
+	lastPitch   float64
+	slur        bool
+	arpeggio    Arpeggio
+	effects     Effects
+	lineEffects *effectChain
+	tiedOver    *Note // note tied over end of line to next line of part
+	tiedMidi    int   // index of exported MIDI note of tiedOver
+	legato      bool  // MIDI legato pedal is on for slurred notes
+	expression  bool  // MIDI expression is changed by a hairpin
+}
+
 // Play music score from reader
 func (m *Music) Play(reader *bufio.Reader, volume100 int) {
+	m.play(reader, volume100, m.output, m.loudness)
+}
+
+// Plays music score from reader into output file normalized to loudness
+// in LUFS, empty output plays to audio device
+func (m *Music) play(reader *bufio.Reader, volume100 int, output string, loudness float64) {
 	m.playing = true
 	defer func() {
 		if m.stopping {
@@ -219,10 +678,11 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 	}()
 
 	volume := int(SampleAmp16bit * (float64(volume100) / 100.0))
-	outputFileName := m.output
+	outputFileName := output
 
 	if m.piano == nil {
 		m.piano = NewPiano()
+		m.piano.setTuning(m.tuning)
 	}
 
 	var outputFile *os.File
@@ -260,8 +720,16 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 
 	// read lines
 	chord := &Chord{}
+	tuplet := &Tuplet{}
+	tempoBPM := &Tempo{}
+	exportMidi := isMidiFile(outputFileName)
+	var midiNotes []midiNote       // notes of MIDI output file
+	var midiTempos []midiTempo     // tempo changes of MIDI output file
+	var midiMeters []midiMeter     // time signatures of MIDI output file
+	var midiControls []midiControl // controller changes of MIDI output file
+	position := 0                  // sample position of current line
 	bufWaveLimit := 1024 * 1024 * 100
-	controlKeys := "RDHTSAVC"
+	controlKeys := "RDHTSAVCE"
 	measures := "WHQESTI"
 	hands := "0LR7"
 	zeroToNine := "0123456789"
@@ -271,7 +739,9 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 	ignoredKeys := "\t |"
 	sustainTypes := "ADSR"
 	sustainLevels := zeroToNine
-	voiceControls := "DPVN"
+	voiceControls := "DPVNSG"
+	articulations := "'^-;"
+	effectTypes := "RDCBMT"
 
 	var (
 		bufOutput    []int16
@@ -279,24 +749,74 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 		dotted       bool
 		rest         rune
 		ctrl         rune
-		voice        Voice = m.piano // default voice is piano
+		voice        Voice  = m.piano // default voice is piano
+		program      string           // General MIDI program number digits
+		programDigit bool
 		sustainType  rune
 		hand         = 'R' // default is middle C octave
 		handLevel    rune
-		count        int // line counter
-		tempo        = 4 // normal speed
-		amplitude    = 9 // max volume
+		count        int                  // line counter
+		tempo        = 4                  // normal speed
+		dynamics     = Dynamics{level: 9} // max volume
+		expression   bool                 // MIDI expression is changed by a hairpin
+		legato       bool                 // MIDI legato pedal is on for slurred notes
+		grace        bool                 // next note is a grace note
+		graces       []*Note              // grace notes played before next note
+		chordMark    rune                 // articulation of current chord
+		chordGap     int                  // samples of staccato rest of current chord
+		parts        = make([]Part, 1)    // state of parts, first part plays lines out of systems
+		part         int                  // part of current line
+		system       Part                 // state of first part at start of system
+		systemTempo  Tempo                // tempo at start of system
+		systemLevel  int                  // tempo level at start of system
+		firstTempo   Tempo                // tempo after first part of system
+		firstLevel   int                  // tempo level after first part of system
+		arpeggio     Arpeggio             // arpeggiator of chords
+		arpeggiated  []*Note              // chord notes collected by arpeggiator
 		mixNextLine  bool
 		bufMix       []int16
 		lineMix      string
 		waitNext     bool
 		blockComment bool
+		effectType   rune
+		detune       float64 // cents of P control
+		glide        bool    // next note glides from previous note
+		lastPitch    float64 // cents of previous note from MIDI note 0, 0 if none
+		tied         *Note   // note tied to next note of same pitch
+		tiedOver     *Note   // note tied over end of previous line of part
+		tiedMidi     int     // index of exported MIDI note of tiedOver
+		continued    bool    // next played note continues tiedOver
+		slur         bool    // notes are slurred
+		slurred      bool    // previous note is slurred into next note
+		barBeats     int     // beats of time signature, 0 if none
+		barUnit      int     // note value of time signature beat
+		barLength    float64 // whole notes played in current bar
+		effects      = Effects{EQ: make([]EQBand, len(notationEQ))}
+		lineEffects  = newEffectChain(effects)
+		master       = newEffectChain(m.effects)
 	)
+	copy(effects.EQ, notationEQ)
 
-	for {
-		line, done := nextMusicLine(reader)
-		if done {
-			break
+	// expand repeats, jumps and sections
+	score, err := ParseScore(reader, m.sheetDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid music sheet:", err)
+		return
+	}
+	var marker string          // position printed before next line after a jump
+	var linePosition ScoreLine // sheet position of line being prepared
+	printLine := func(line string) {
+		if len(marker) > 0 {
+			fmt.Println("#", marker)
+			marker = ""
+		}
+		fmt.Println(line)
+	}
+
+	for _, sheetLine := range score.Lines {
+		line := sheetLine.Text
+		if sheetLine.jump {
+			marker = sheetLine.String()
 		}
 		if strings.HasPrefix(line, "#") {
 			if strings.HasPrefix(line, "##") {
@@ -309,7 +829,7 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 			} else {
 				// ignore comments
 				if PrintSheet {
-					fmt.Println(line)
+					printLine(line)
 				}
 			}
 			continue
@@ -317,6 +837,50 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 		if blockComment {
 			continue
 		}
+		if !mixNextLine {
+			linePosition = sheetLine // first line of lines mixed by VN
+		}
+
+		// switch to state of the part of line
+		next := 0
+		if mixNextLine {
+			next = part + 1
+		}
+		parts[part] = Part{voice, duration, hand, dynamics, *sustain, sustainType,
+			*chord, chordMark, detune, lastPitch, slur, arpeggio, effects, lineEffects,
+			tiedOver, tiedMidi, legato, expression}
+		switch {
+		case next == 0 && part > 0:
+			// tempo advances once per system, by its first part
+			*tempoBPM, tempo = firstTempo, firstLevel
+		case next == 1:
+			firstTempo, firstLevel = *tempoBPM, tempo
+		}
+		if next == 0 {
+			system = parts[0]
+			systemTempo, systemLevel = *tempoBPM, tempo
+		} else {
+			// parts play from tempo at start of system
+			*tempoBPM, tempo = systemTempo, systemLevel
+		}
+		if next == len(parts) {
+			// new part starts with state of first part at start of system
+			p := system
+			p.sustain.buf = make([]int16, len(system.sustain.buf))
+			p.chord = Chord{}
+			p.tiedOver, p.legato, p.expression = nil, false, false
+			p.effects.EQ = append([]EQBand(nil), system.effects.EQ...)
+			p.lineEffects = newEffectChain(p.effects)
+			parts = append(parts, p)
+		}
+		p := &parts[next]
+		voice, duration, hand, dynamics = p.voice, p.duration, p.hand, p.dynamics
+		*sustain, sustainType, *chord, chordMark = p.sustain, p.sustainType, p.chord, p.chordMark
+		detune, lastPitch, slur, arpeggio = p.detune, p.lastPitch, p.slur, p.arpeggio
+		effects, lineEffects = p.effects, p.lineEffects
+		tiedOver, tiedMidi, legato, expression = p.tiedOver, p.tiedMidi, p.legato, p.expression
+		part = next
+
 		if strings.HasSuffix(line, "VN") {
 			// include next line to mixer
 			mixNextLine = true
@@ -324,11 +888,267 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 			mixNextLine = false
 		}
 		var bufWave []int16
-		for _, key := range line {
+		tuplet.Reset() // tuplets end with line
+		arpeggiated = nil
+		slurred = false
+		barLength = 0
+		dynamics.set(dynamics.level) // hairpins end with line
+		keys := []rune(line)
+		// renders rest into line wave, releasing sustained natural voice
+		playRest := func(bufRest []int16) {
+			if voice.NaturalVoice() {
+				releaseNote(sustain.buf, 0, sustain.Ratio())
+				mixSoundWave(bufRest, sustain.buf)
+				clearBuffer(sustain.buf)
+			}
+			bufWave = append(bufWave, bufRest...)
+		}
+		// renders note into line wave
+		playNote := func(note *Note) (played bool) {
+			note.slurIn = slurred
+			slurred = note.slurOut
+			extend := continued
+			continued = false
+			if chord.number == 0 || chord.count == 0 {
+				chordGap = 0
+				if note.articulation == '\'' {
+					// staccato, rest after half of the note
+					chordGap = note.samples / 2
+				}
+			}
+			note.samples -= chordGap
+			if voice.GetNote(note, sustain) {
+				if note.amplitude > 0 && (note.swell != 0 || note.level != float64(note.amplitude)) {
+					applyNoteSwell(note.buf, note.samples, note.amplitude, note.level, note.swell)
+				}
+				if note.articulation == '^' {
+					applyAccent(note.buf)
+				}
+				if exportMidi {
+					// chord notes start together
+					start := position + len(bufWave)
+					channel := midiChannel(part)
+					if n, found := newMidiNote(note, start, channel); found {
+						if note.swell != 0 {
+							// velocity of louder end, expression ramps the note
+							loud := math.Max(note.level, note.level+note.swell)
+							n.velocity = int(loud*127/9 + 0.5)
+							if chord.count == 0 {
+								for i := 0; i < 8; i++ {
+									level := note.level + note.swell*float64(i)/8
+									midiControls = append(midiControls,
+										midiControl{start + note.samples*i/8, 11, int(level*127/loud + 0.5), channel})
+								}
+								expression = true
+							}
+						} else if expression && chord.count == 0 {
+							midiControls = append(midiControls, midiControl{start, 11, 127, channel})
+							expression = false
+						}
+						if note.slurOut && slur && !legato && chord.count == 0 {
+							// legato pedal joins slurred notes
+							midiControls = append(midiControls, midiControl{start, 68, 127, channel})
+							legato = true
+						} else if !note.slurOut && legato && chord.count == 0 {
+							midiControls = append(midiControls, midiControl{start + note.samples, 68, 0, channel})
+							legato = false
+						}
+						if extend && tiedMidi < len(midiNotes) && midiNotes[tiedMidi].number == n.number {
+							// tied over end of line, extend note of previous line
+							midiNotes[tiedMidi].samples = start + n.samples - midiNotes[tiedMidi].start
+						} else {
+							midiNotes = append(midiNotes, n)
+						}
+					}
+					// tempo map follows first part of systems
+					quarter := float64(wholeNoteLength(note.tempo, note.bpm)) / 4
+					if last := len(midiTempos) - 1; part == 0 && (last < 0 || midiTempos[last].quarter != quarter) {
+						midiTempos = append(midiTempos, midiTempo{start, quarter})
+					}
+				}
+				if chord.number > 0 {
+					// playing a chord
+					chord.count++
+					if chord.buf == nil {
+						chord.buf = make([]int16, len(note.buf))
+						copy(chord.buf, note.buf)
+						if voice.NaturalVoice() {
+							//copyBuffer(sustain.buf, chord.buf)
+						}
+					} else {
+						mixSoundWave(chord.buf, note.buf)
+						if voice.NaturalVoice() {
+							//mixSoundWave(sustain.buf, note.buf)
+						}
+					}
+					if chord.count == chord.number {
+						if voice.NaturalVoice() {
+							release := len(note.buf) / 10 * sustain.sustain
+							ratio := sustain.Ratio()
+							releaseNote(sustain.buf, release, ratio)
+						}
+						note.buf = chord.buf
+						chord.Reset()
+					} else {
+						if PrintNotes {
+							fmt.Printf("%v-", m.piano.keyNoteMap[note.key])
+						}
+						return
+					}
+				}
+				voice.SustainNote(note, sustain)
+				bufWave = append(bufWave, note.buf...)
+				if chordGap > 0 {
+					playRest(make([]int16, chordGap))
+				}
+				played = true
+				if len(bufWave) > bufWaveLimit {
+					fmt.Fprintln(os.Stderr, "Line wave buffer exceeds 100MB limit.")
+					os.Exit(1)
+				}
+				if PrintNotes {
+					fmt.Printf("%v ", m.piano.keyNoteMap[note.key])
+				}
+			} else {
+				voiceName := strings.Split(fmt.Sprintf("%T", voice), ".")[1]
+				noteName := m.piano.keyNoteMap[note.key]
+				fmt.Printf("%s: Invalid note: %s (%s)\n", voiceName, string(note.key%1000), noteName)
+			}
+			return
+		}
+		// advances tuplet, tempo change and bar by played note or rest
+		advance := func(samples, length int) {
+			tuplet.next()
+			tempoBPM.next(samples)
+			dynamics.next(4 * float64(samples) / float64(length))
+			barLength += float64(samples) / float64(length)
+		}
+		for k := 0; k < len(keys); k++ {
+			key := keys[k]
 			keystr := string(key)
+			if key == '|' && barBeats > 0 && barLength > 0 {
+				// validate bar length
+				if math.Abs(barLength*float64(barUnit)-float64(barBeats)) > 0.05 {
+					fmt.Fprintf(os.Stderr, "Bar length %.3g/%d does not match time signature %d/%d: %s\n",
+						barLength*float64(barUnit), barUnit, barBeats, barUnit, line)
+				}
+				barLength = 0
+			}
 			if strings.ContainsAny(keystr, ignoredKeys) {
 				continue
 			}
+			if ctrl == 0 && key == 'P' {
+				// detune following notes, P0 resets
+				cents, n := parseCents(keys[k+1:])
+				if n == 0 {
+					fmt.Fprintln(os.Stderr, "Invalid pitch offset:", line)
+				}
+				detune = cents
+				k += n
+				continue
+			}
+			if ctrl == 0 && key == 'U' {
+				// tuplet of following notes like U3:2
+				notes, span, n := parseTuplet(keys[k+1:])
+				if n == 0 {
+					fmt.Fprintln(os.Stderr, "Invalid tuplet:", line)
+				}
+				tuplet.set(notes, span)
+				k += n
+				continue
+			}
+			if ctrl == 0 && key == 'T' && k+1 < len(keys) && strings.ContainsRune("=<>", keys[k+1]) {
+				// beats per minute like T=120, gradual change like T>160:8
+				change, bpm, beats, n := parseTempo(keys[k+1:])
+				if n == 0 {
+					fmt.Fprintln(os.Stderr, "Invalid tempo:", line)
+				} else if change == '=' {
+					tempoBPM.set(float64(bpm))
+				} else {
+					from := tempoBPM.bpm
+					if from == 0 {
+						from = 4 * 60 * SampleRate64 / float64(wholeNoteLength(tempo, 0))
+					}
+					if beats == 0 {
+						beats = 4 // one bar of 4/4
+					}
+					tempoBPM.change(from, float64(bpm), float64(beats))
+				}
+				k += n
+				continue
+			}
+			if ctrl == 0 && key == '!' {
+				// dynamics like !mf, hairpins like !< or !>p:8
+				hairpin, level, beats, n := parseDynamics(keys[k+1:])
+				switch {
+				case n == 0:
+					fmt.Fprintln(os.Stderr, "Invalid dynamics:", line)
+				case hairpin == 0:
+					dynamics.set(float64(level))
+				case level > 0:
+					dynamics.change(float64(level), float64(beats), false)
+				default:
+					// hairpin to next dynamic marking of the line
+					steps, target := hairpinSpan(string(keys[k+n+1:]))
+					if target == 0 || steps == 0 {
+						fmt.Fprintln(os.Stderr, "Hairpin must end with a dynamic marking after notes:", line)
+						break
+					}
+					dynamics.change(float64(target), float64(steps), true)
+				}
+				k += n
+				continue
+			}
+			if ctrl == 0 && key == '<' {
+				// chord symbol like <Cmaj7> or <Am/G:open>
+				end, found := chordSymbolEnd(keys, k)
+				if !found {
+					// notes after the symbol are played
+					fmt.Fprintln(os.Stderr, "Chord symbol must end with >:", string(keys[k:end+1]))
+					k = end
+					continue
+				}
+				var articulation rune
+				after := keys[end+1:]
+				if len(after) > 0 && strings.ContainsRune(articulations, after[0]) {
+					articulation, after = after[0], after[1:]
+				}
+				text, err := chordNotation(string(keys[k+1:end]), m.piano.noteKeyMap, hand, articulation)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "Invalid chord symbol:", err)
+					k = end
+					continue
+				}
+				// play keys of the chord in place of the symbol
+				keys = append(append(append([]rune{}, keys[:k]...), []rune(text)...), after...)
+				k--
+				continue
+			}
+			if ctrl == 0 && key == 'X' {
+				// arpeggiator like XUE, XB2S:50, X0 is off
+				mode, rate, octaves, gate, n := parseArpeggio(keys[k+1:])
+				if n == 0 {
+					fmt.Fprintln(os.Stderr, "Invalid arpeggiator:", line)
+				}
+				arpeggio = Arpeggio{mode, rate, octaves, gate}
+				k += n
+				continue
+			}
+			if ctrl == 0 && key == 'M' {
+				// time signature like M3/4
+				beats, unit, n := parseTimeSignature(keys[k+1:])
+				if n == 0 {
+					fmt.Fprintln(os.Stderr, "Invalid time signature:", line)
+				} else {
+					barBeats, barUnit = beats, unit
+					barLength = 0
+					if exportMidi {
+						midiMeters = append(midiMeters, midiMeter{position + len(bufWave), beats, unit})
+					}
+				}
+				k += n
+				continue
+			}
 			if ctrl == 0 && strings.ContainsAny(keystr, controlKeys) {
 				ctrl = key
 				continue
@@ -353,6 +1173,7 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 				case 'T': // tempo
 					if strings.ContainsAny(keystr, tempos) {
 						tempo = strings.Index(tempos, keystr)
+						tempoBPM.set(0)
 					}
 				case 'S': // sustain
 					if strings.ContainsAny(keystr, sustainTypes) {
@@ -374,10 +1195,23 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 					}
 				case 'A': // amplitude
 					if strings.ContainsAny(keystr, amplitudes) {
-						amplitude = strings.Index(amplitudes, keystr)
+						dynamics.set(float64(strings.Index(amplitudes, keystr)))
 					}
 				case 'V': // voice
-					if strings.ContainsAny(keystr, voiceControls) {
+					if programDigit {
+						if strings.ContainsAny(keystr, zeroToNine) {
+							program += keystr
+							if len(program) < 3 {
+								continue
+							}
+							if gm := m.programVoice(0, stringNumber(program)); gm != nil {
+								voice = gm
+							} else {
+								fmt.Fprintln(os.Stderr, "SoundFont is not loaded.")
+							}
+						}
+						programDigit = false
+					} else if strings.ContainsAny(keystr, voiceControls) {
 						switch key {
 						case 'D': // default voice
 							voice.ComputerVoice(true)
@@ -389,9 +1223,20 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 						case 'V':
 							if m.violin == nil {
 								m.violin = NewViolin()
+								m.violin.setTuning(m.tuning)
 							}
 							voice = m.violin
 							voice.ComputerVoice(false)
+						case 'S':
+							if m.sfz == nil {
+								fmt.Fprintln(os.Stderr, "SFZ voice is not loaded.")
+								break
+							}
+							voice = m.sfz
+						case 'G': // General MIDI program
+							program = ""
+							programDigit = true
+							continue
 						}
 					}
 				case 'C': // chord
@@ -399,22 +1244,75 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 						chord.count = 0
 						chord.number = strings.Index(chordNumbers, keystr)
 					}
+				case 'E': // effects
+					if key == 'X' {
+						effects.Reverb, effects.Delay, effects.Chorus = 0, 0, 0
+						for i := range effects.EQ {
+							effects.EQ[i].Gain = 0
+						}
+						lineEffects.set(effects)
+						break
+					}
+					if strings.ContainsAny(keystr, effectTypes) {
+						effectType = key
+						continue
+					}
+					if strings.ContainsAny(keystr, zeroToNine) {
+						level := strings.Index(zeroToNine, keystr)
+						switch effectType {
+						case 'R':
+							effects.Reverb = level
+						case 'D':
+							effects.Delay = level
+						case 'C':
+							effects.Chorus = level
+						case 'B', 'M', 'T':
+							band := strings.IndexRune("BMT", effectType)
+							effects.EQ[band].Gain = float64(level-5) * 3
+						}
+						lineEffects.set(effects)
+					}
 				}
 				if rest > 0 {
-					bufRest := restNote(rest, dotted, tempo)
-					if bufRest != nil {
-						if voice.NaturalVoice() {
-							releaseNote(sustain.buf, 0, sustain.Ratio())
-							mixSoundWave(bufRest, sustain.buf)
-							clearBuffer(sustain.buf)
+					if tied != nil || tiedOver != nil {
+						fmt.Fprintln(os.Stderr, "Tied notes must be followed by a note:", line)
+						if tied != nil {
+							playNote(tied)
 						}
-						bufWave = append(bufWave, bufRest...)
+						tied, tiedOver = nil, nil
 					}
+					length := wholeNoteLength(tempo, tempoBPM.bpm)
+					bufRest := restNote(rest, dotted, length, tuplet)
+					advance(len(bufRest), length)
+					if bufRest != nil {
+						playRest(bufRest)
+					}
+					if len(graces) > 0 {
+						fmt.Fprintln(os.Stderr, "Grace notes must be followed by a note:", line)
+						graces = nil
+					}
+					slurred = false
 					rest = 0
 				}
 				ctrl = 0
 				continue
 			}
+			switch key {
+			case '/': // glide into next note
+				glide = true
+				continue
+			case '(': // slur start
+				slur = true
+				continue
+			case ')': // slur end
+				slur = false
+				continue
+			case '_': // tie without a previous note
+				continue
+			case '\\': // grace note
+				grace = true
+				continue
+			}
 			switch hand {
 			case '0': // octave 0
 				handLevel = 1000
@@ -428,63 +1326,169 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 			note := &Note{
 				key:       handLevel + key,
 				volume:    volume,
-				amplitude: amplitude,
+				amplitude: int(dynamics.level + 0.5),
 				duration:  duration,
 				dotted:    dotted,
 				tempo:     tempo,
+				bpm:       tempoBPM.bpm,
 				samples:   0,
+				cents:     detune,
+				tuplet:    tuplet,
+				level:     dynamics.level,
+			}
+			if k+1 < len(keys) && keys[k+1] == '~' {
+				// cents offset of note like q~+50
+				cents, n := parseCents(keys[k+2:])
+				if n == 0 {
+					fmt.Fprintln(os.Stderr, "Invalid pitch offset:", line)
+				}
+				note.cents += cents
+				k += n + 1
+			}
+			if k+1 < len(keys) && strings.ContainsRune(articulations, keys[k+1]) {
+				// articulation of note like q' or q^
+				note.articulation = keys[k+1]
+				k++
+			}
+			if chord.number > 0 {
+				// chord notes are articulated as first note
+				if chord.count == 0 {
+					chordMark = note.articulation
+				}
+				note.articulation = chordMark
+			}
+			if number, found := keyMidiNoteMap[note.key]; found {
+				pitch := float64(number)*100 + note.cents
+				if glide && lastPitch > 0 {
+					note.glide = lastPitch - pitch
+				}
+				lastPitch = pitch
+			}
+			glide = false
+			if grace {
+				// grace note of a 32nd note, played before next note
+				grace = false
+				note.samples = wholeNoteLength(note.tempo, note.bpm) / 32
+				graces = append(graces, note)
+				continue
 			}
 			note.measure()
-			if voice.GetNote(note, sustain) {
-				dotted = false
-				if chord.number > 0 {
-					// playing a chord
-					chord.count++
-					if chord.buf == nil {
-						chord.buf = make([]int16, len(note.buf))
-						copy(chord.buf, note.buf)
-						if voice.NaturalVoice() {
-							//copyBuffer(sustain.buf, chord.buf)
-						}
-					} else {
-						mixSoundWave(chord.buf, note.buf)
-						if voice.NaturalVoice() {
-							//mixSoundWave(sustain.buf, note.buf)
-						}
+			dotted = false
+			samples, length := note.samples, wholeNoteLength(note.tempo, note.bpm)
+			note.swell = dynamics.after(4*float64(samples)/float64(length)) - note.level
+			if note.articulation == ';' {
+				// fermata, held beyond beat
+				note.samples *= 2
+			}
+			if len(graces) > 0 {
+				// grace notes steal time from beginning of note
+				stolen := 0
+				for _, g := range graces {
+					if g.samples > note.samples/2/len(graces) {
+						g.samples = note.samples / 2 / len(graces)
 					}
-					if chord.count == chord.number {
-						if voice.NaturalVoice() {
-							release := len(note.buf) / 10 * sustain.sustain
-							ratio := sustain.Ratio()
-							releaseNote(sustain.buf, release, ratio)
-						}
-						note.buf = chord.buf
-						chord.Reset()
-					} else {
-						if PrintNotes {
-							fmt.Printf("%v-", m.piano.keyNoteMap[note.key])
-						}
-						continue
+					stolen += g.samples
+				}
+				number := chord.number
+				if chord.count == 0 {
+					chord.number = 0 // grace notes before chord
+				}
+				for _, g := range graces {
+					playNote(g)
+				}
+				chord.number = number
+				note.samples -= stolen
+				graces = nil
+			}
+			if tiedOver != nil {
+				if tiedOver.key == note.key && chord.number == 0 {
+					// continue note of previous line without attack
+					slurred, continued = true, true
+				} else {
+					fmt.Fprintln(os.Stderr, "Tied notes must have the same pitch:", line)
+				}
+				tiedOver = nil
+			}
+			if tied != nil {
+				if tied.key == note.key && chord.number == 0 {
+					// continue tied note without attack
+					tied.samples += note.samples
+					tied.swell += note.swell
+					if tied.articulation == 0 {
+						tied.articulation = note.articulation
 					}
+					note = tied
+				} else {
+					fmt.Fprintln(os.Stderr, "Tied notes must have the same pitch:", line)
+					playNote(tied)
 				}
-				voice.SustainNote(note, sustain)
-				bufWave = append(bufWave, note.buf...)
-				if len(bufWave) > bufWaveLimit {
-					fmt.Fprintln(os.Stderr, "Line wave buffer exceeds 100MB limit.")
-					os.Exit(1)
+				tied = nil
+			}
+			next := nextKey(keys, k, ignoredKeys)
+			if next < len(keys) && keys[next] == '_' && chord.number == 0 {
+				// tie to next note
+				tied = note
+				advance(samples, length)
+				k = next
+				continue
+			}
+			note.slurOut = slur && next < len(keys) && keys[next] != ')'
+			if arpeggio.mode != 0 && chord.number > 0 {
+				// play chord as steps of arpeggiator in time of the chord
+				arpeggiated = append(arpeggiated, note)
+				if len(arpeggiated) < chord.number {
+					continue
 				}
-				if PrintNotes {
-					fmt.Printf("%v ", m.piano.keyNoteMap[note.key])
+				chord.Reset()
+				var chordKeys []rune
+				for _, n := range arpeggiated {
+					chordKeys = append(chordKeys, n.key)
 				}
-				if m.stopping {
-					break
+				steps := arpeggio.pattern(chordKeys)
+				step := durationSamples(arpeggio.rate, false, length)
+				random := arpeggioRandom(line, k)
+				for i, played := 0, 0; played < arpeggiated[0].samples && len(steps) > 0 && step > 0; i++ {
+					n := *arpeggiated[0]
+					n.key = steps[i%len(steps)]
+					if arpeggio.mode == 'R' {
+						n.key = steps[random.Intn(len(steps))]
+					}
+					n.samples = step
+					if n.samples > arpeggiated[0].samples-played {
+						n.samples = arpeggiated[0].samples - played
+					}
+					gap := n.samples - n.samples*arpeggio.gate/100
+					n.samples -= gap
+					n.swell, n.buf, n.slurOut = 0, nil, false
+					played += n.samples + gap
+					playNote(&n)
+					if gap > 0 {
+						playRest(make([]int16, gap))
+					}
 				}
-			} else {
-				voiceName := strings.Split(fmt.Sprintf("%T", voice), ".")[1]
-				noteName := m.piano.keyNoteMap[note.key]
-				fmt.Printf("%s: Invalid note: %s (%s)\n", voiceName, keystr, noteName)
+				arpeggiated = nil
+				advance(samples, length)
+				continue
+			}
+			if playNote(note) {
+				advance(samples, length)
+			}
+			if m.stopping {
+				break
 			}
 		}
+		if tied != nil {
+			// tie continues in next line of the part, note is held
+			tied.slurOut = true
+			playNote(tied)
+			tiedOver, tiedMidi = tied, len(midiNotes)-1
+			tied = nil
+		}
+		if len(graces) > 0 {
+			fmt.Fprintln(os.Stderr, "Grace notes must be followed by a note:", line)
+			graces = nil
+		}
+		lineEffects.process(bufWave)
 		if mixNextLine {
 			if bufMix == nil {
 				bufMix = make([]int16, len(bufWave))
@@ -507,6 +1511,7 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 		if PrintNotes {
 			fmt.Println()
 		}
+		master.process(bufWave)
 		if outputFile == nil {
 			if len(bufWave) > 0 {
 				if waitNext {
@@ -516,16 +1521,18 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 					break
 				}
 				// prepare next line while playing
+				m.setPosition(linePosition)
 				go m.Playback(bufWave, bufWave)
 				if PrintSheet {
-					fmt.Println(line)
+					printLine(line)
 				}
 				waitNext = true
 			} else if PrintSheet {
-				fmt.Println(line)
+				printLine(line)
 			}
 		} else {
 			// saving to file
+			m.setPosition(linePosition)
 			buf := make([]int16, 2*len(bufWave))
 			for i, bar := range bufWave {
 				buf[i*2] = bar
@@ -533,21 +1540,72 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 			}
 			bufOutput = append(bufOutput, buf...)
 			if PrintSheet {
-				fmt.Println(line)
+				printLine(line)
 			}
 		}
 		clearBuffer(sustain.buf)
+		position += len(bufWave)
 		count++
 		if m.stopping {
 			break
 		}
 	}
+	parts[part].lineEffects = lineEffects
+	tail := 0
+	for _, p := range parts {
+		if n := p.lineEffects.tailLength(); n > tail {
+			tail = n
+		}
+	}
+	if tail += master.tailLength(); tail > 0 && !m.stopping {
+		// let reverb and delay ring out
+		var bufWave []int16
+		for _, p := range parts {
+			if p.lineEffects.tailLength() == 0 {
+				continue
+			}
+			bufTail := make([]int16, tail)
+			p.lineEffects.process(bufTail)
+			if bufWave == nil {
+				bufWave = bufTail
+			} else {
+				mixSoundWave(bufWave, bufTail)
+			}
+		}
+		if bufWave == nil {
+			bufWave = make([]int16, tail)
+		}
+		master.process(bufWave)
+		if outputFile == nil {
+			if waitNext {
+				m.WaitLine()
+			}
+			go m.Playback(bufWave, bufWave)
+			waitNext = true
+		} else {
+			for _, bar := range bufWave {
+				bufOutput = append(bufOutput, bar, bar)
+			}
+		}
+	}
 	if waitNext && !m.stopping {
 		m.WaitLine()
 	}
 
-	if outputFile != nil {
+	if outputFile != nil && exportMidi {
+		// save notes to MIDI file
+		if err := writeMidiFile(outputFile, midiNotes, midiTempos, midiMeters, midiControls); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing to output file:", err)
+			os.Exit(1)
+		}
+		if outputFileName != "-" {
+			fmt.Printf("wrote %d notes to '%s'\n", len(midiNotes), outputFileName)
+		}
+	} else if outputFile != nil {
 		// save wave to file
+		if loudness != 0 {
+			NormalizeLoudness(bufOutput, 2, loudness)
+		}
 		buflen := len(bufOutput)
 		header := NewWaveHeader(2, SampleRate, 16, buflen*2)
 		_, err = header.WriteHeader(outputFile)
@@ -569,9 +1627,25 @@ func (m *Music) Play(reader *bufio.Reader, volume100 int) {
 
 // measure sets the number of samples for the node
 func (n *Note) measure() {
+	n.samples = durationSamples(n.duration, n.dotted, wholeNoteLength(n.tempo, n.bpm))
+	if n.tuplet != nil {
+		n.samples = n.tuplet.measure(n.samples)
+	}
+}
+
+// Returns number of samples of whole note at tempo level 0-9,
+// or at beats per minute of quarter notes if bpm is not 0
+func wholeNoteLength(tempo int, bpm float64) int {
+	if bpm > 0 {
+		return int(4*60*SampleRate64/bpm + 0.5)
+	}
+	return wholeNote + (wholeNote / 100 * 4 * (4 - tempo)) // 4% per tempo unit
+}
+
+// Returns number of samples of duration in whole note length
+func durationSamples(duration rune, dotted bool, length int) int {
 	var samples int
-	length := wholeNote + (wholeNote / 100 * 4 * (4 - n.tempo)) // 4% per tempo unit
-	switch n.duration {
+	switch duration {
 	case 'W':
 		samples = length
 	case 'H':
@@ -590,12 +1664,32 @@ func (n *Note) measure() {
 
 	if samples > 0 {
 		// Apply dot measure
-		if n.dotted {
+		if dotted {
 			samples += samples / 2
 		}
 	}
 
-	n.samples = samples
+	return samples
+}
+
+// Returns true if note is held for its full length without release
+func (n *Note) held() bool {
+	return n.slurOut || n.articulation == '-'
+}
+
+// Returns true if note pitch is offset from its key
+func (n *Note) bent() bool {
+	return n.cents != 0 || n.glide != 0
+}
+
+// Returns cents offset from key at sample i, a glide reaches the note
+// pitch at the end of the note
+func (n *Note) pitchCents(i int) float64 {
+	cents := n.cents
+	if n.glide != 0 && i < n.samples {
+		cents += n.glide * (1 - float64(i)/float64(n.samples))
+	}
+	return cents
 }
 
 // Reads next line from music sheet
@@ -635,6 +1729,35 @@ func applyNoteVolume(buf []int16, volume, amplitude int) {
 	}
 }
 
+// Ramps note amplitude from level to level + swell over samples, relative
+// to amplitude the note was rendered with
+func applyNoteSwell(buf []int16, samples, amplitude int, level, swell float64) {
+	if samples <= 0 {
+		return
+	}
+	amplitude64 := float64(amplitude)
+	for i, bar := range buf {
+		progress := 1.0
+		if i < samples {
+			progress = float64(i) / float64(samples)
+		}
+		bar64 := float64(bar) * (level + swell*progress) / amplitude64
+		buf[i] = int16(math.Max(-SampleAmp16bit, math.Min(SampleAmp16bit, bar64)))
+	}
+}
+
+// Boosts attack of accented note
+func applyAccent(buf []int16) {
+	attack := len(buf) / 4
+	if attack > SampleRate/20 {
+		attack = SampleRate / 20
+	}
+	for i := 0; i < attack; i++ {
+		bar64 := float64(buf[i]) * (1.5 - 0.5*float64(i)/float64(attack))
+		buf[i] = int16(math.Max(-SampleAmp16bit, math.Min(SampleAmp16bit, bar64)))
+	}
+}
+
 // Mixes two waveform
 func mixSoundWave(buf1, buf2 []int16) {
 	buflen2 := len(buf2)
@@ -753,30 +1876,11 @@ func raiseNote(buf []int16, ratio float64) {
 	}
 }
 
-// Returns rest note buffer with tempo
-func restNote(rest rune, dotted bool, tempo int) []int16 {
-	var samples int
-	length := wholeNote + (wholeNote / 100 * 4 * (4 - tempo)) // 4% per tempo unit
-	switch rest {
-	case 'W':
-		samples = length
-	case 'H':
-		samples = length / 2
-	case 'Q':
-		samples = length / 4
-	case 'E':
-		samples = length / 8
-	case 'S':
-		samples = length / 16
-	case 'T':
-		samples = length / 32
-	case 'I':
-		samples = length / 64
+// Returns rest note buffer in whole note length, shortened by tuplet if not nil
+func restNote(rest rune, dotted bool, length int, tuplet *Tuplet) []int16 {
+	samples := durationSamples(rest, dotted, length)
+	if tuplet != nil {
+		samples = tuplet.measure(samples)
 	}
-
-	if dotted {
-		samples += samples / 2
-	}
-
 	return make([]int16, samples)
 }
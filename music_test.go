@@ -45,7 +45,7 @@ func ExampleNote_measure_tempo_9() {
 }
 
 func Example_restNote_tempo_6() {
-	buf := restNote('W', false, 6)
+	buf := restNote('W', false, wholeNoteLength(6, 0), nil)
 	fmt.Println("Temp 6:", len(buf))
 
 	// Output:
@@ -53,9 +53,233 @@ func Example_restNote_tempo_6() {
 }
 
 func Example_restNote_tempo_0() {
-	buf := restNote('Q', false, 0)
+	buf := restNote('Q', false, wholeNoteLength(0, 0), nil)
 	fmt.Println("Temp 0:", len(buf))
 
 	// Output:
 	// Temp 0: 26132
 }
+
+func ExampleNote_pitchCents_glide() {
+	note := &Note{
+		samples: 1000,
+		cents:   50,
+		glide:   -200, // from two semitones below
+	}
+	for _, i := range []int{0, 500, 1000} {
+		fmt.Printf("%d: %+.0f cents\n", i, note.pitchCents(i))
+	}
+
+	// Output:
+	// 0: -150 cents
+	// 500: -50 cents
+	// 1000: +50 cents
+}
+
+func Example_parseCents() {
+	for _, s := range []string{"+50q", "-25", "7e", "q"} {
+		cents, n := parseCents([]rune(s))
+		fmt.Println(s, cents, n)
+	}
+
+	// Output:
+	// +50q 50 3
+	// -25 -25 3
+	// 7e 7 1
+	// q 0 0
+}
+
+func Example_nextKey() {
+	// tie across a bar line
+	keys := []rune("q_ | q (we)")
+	for _, k := range []int{0, 1, 5} {
+		next := nextKey(keys, k, "\t |")
+		fmt.Println(k, next, string(keys[next]))
+	}
+	fmt.Println(nextKey(keys, len(keys)-1, "\t |"))
+
+	// Output:
+	// 0 1 _
+	// 1 5 q
+	// 5 7 (
+	// 11
+}
+
+func Example_tuplet() {
+	// triplet of eighth notes takes time of a quarter note
+	tuplet := &Tuplet{}
+	tuplet.set(3, 2)
+	total := 0
+	for i := 0; i < 3; i++ {
+		note := &Note{duration: 'E', tempo: 4, tuplet: tuplet}
+		note.measure()
+		tuplet.next()
+		total += note.samples
+		fmt.Print(note.samples, " ")
+	}
+	fmt.Println(total, quarterNote)
+	fmt.Println(parseTuplet([]rune("5q")))
+	fmt.Println(parseTuplet([]rune("7:8q")))
+
+	// Output:
+	// 7509 7509 7510 22528 22528
+	// 5 4 1
+	// 7 8 3
+}
+
+func Example_wholeNoteLength() {
+	fmt.Println("T4:", wholeNoteLength(4, 0))
+	fmt.Println("T=120:", wholeNoteLength(4, 120))
+
+	// ritardando from 120 to 60 BPM over 2 beats
+	tempo := &Tempo{}
+	tempo.change(120, 60, 2)
+	for i := 0; i < 3; i++ {
+		fmt.Printf("%.0f ", tempo.bpm)
+		tempo.next(wholeNoteLength(0, tempo.bpm) / 4)
+	}
+	fmt.Printf("%.0f\n", tempo.bpm)
+
+	// quarter notes at 120 BPM after 4 quarter notes at T4
+	tempos := []midiTempo{{0, quarterNote}, {4 * quarterNote, 22050}}
+	fmt.Println(sampleTicks(4*quarterNote, tempos), sampleTicks(4*quarterNote+22050, tempos))
+
+	// Output:
+	// T4: 90112
+	// T=120: 88200
+	// 120 90 60 60
+	// 1920 2400
+}
+
+func Example_dynamics() {
+	for _, s := range []string{"pp q", "mf", "<", ">p:8", "<f", "x"} {
+		fmt.Println(parseDynamics([]rune(s)))
+	}
+
+	// crescendo from p to f over the notes before !f, chord is one note
+	fmt.Println(hairpinSpan("qw C3qet RQ e !f r"))
+	dynamics := &Dynamics{level: 3}
+	dynamics.change(8, 4, true)
+	for i := 0; i < 4; i++ {
+		fmt.Printf("%.2f ", dynamics.level)
+		dynamics.next(1)
+	}
+	fmt.Printf("%.2f\n", dynamics.level)
+
+	// Output:
+	// 0 2 0 2
+	// 0 6 0 2
+	// 60 0 0 1
+	// 62 3 8 4
+	// 0 0 0 0
+	// 0 0 0 0
+	// 5 8
+	// 3.00 4.25 5.50 6.75 8.00
+}
+
+func Example_applyAccent() {
+	buf := []int16{1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000}
+	applyAccent(buf)
+	fmt.Println(buf)
+
+	// grace note takes time of next note, chord is one note
+	var texts []string
+	for _, token := range notationTokens(`q' \w C3e^tu`) {
+		texts = append(texts, token.text)
+	}
+	fmt.Printf("%q\n", texts)
+	fmt.Println(hairpinSpan(`q' \w e; C3e^tu !f`))
+
+	// Output:
+	// [1500 1250 1000 1000 1000 1000 1000 1000]
+	// ["q'" " " "\\" "w" " " "C3" "e^" "t" "u"]
+	// 3 8
+}
+
+func Example_arpeggio() {
+	fmt.Println(parseArpeggio([]rune("UE")))
+	fmt.Println(parseArpeggio([]rune("BS2:50q")))
+	fmt.Println(parseArpeggio([]rune("0")))
+
+	// C major chord up and down over two octaves
+	arpeggio := &Arpeggio{mode: 'B', rate: 'S', octaves: 2, gate: 50}
+	var names []string
+	for _, key := range arpeggio.pattern([]rune{3000 + 't', 3000 + 'q', 3000 + 'e'}) {
+		names = append(names, midiNoteMap[keyMidiNoteMap[key]])
+	}
+	fmt.Println(names)
+
+	// random steps are the same for a chord, other chords play other steps
+	for _, column := range []int{6, 6, 10} {
+		random := arpeggioRandom("XRS <C> <C>", column)
+		fmt.Printf("%d%d%d ", random.Intn(6), random.Intn(6), random.Intn(6))
+	}
+	fmt.Println()
+
+	// Output:
+	// 85 69 1 80 2
+	// 66 83 2 50 6
+	// 0 0 0 0 1
+	// [HRq HRe HRt HRi HRp HR] HRp HRi HRt HRe]
+	// 244 244 042
+}
+
+func ExamplePiano_GetNote_tied() {
+	// quarter note tied to two whole notes sounds to its end
+	piano := NewPiano()
+	sustain := &Sustain{buf: make([]int16, quarterNote)}
+	note := &Note{key: 3000 + 'q', duration: 'Q', volume: SampleAmp16bit, amplitude: 9, tempo: 4}
+	note.measure()
+	note.samples += 2 * wholeNote
+	note.slurOut = true
+	piano.GetNote(note, sustain)
+	peak := 0
+	for _, bar := range note.buf[len(note.buf)-quarterNote:] {
+		if int(bar) > peak {
+			peak = int(bar)
+		}
+	}
+	fmt.Println(len(note.buf) == note.samples, peak > 0)
+
+	// Output:
+	// true true
+}
+
+func Example_parts() {
+	// parts keep voice, octave, duration and sustain over systems, both
+	// parts play the ritardando from start of system, a note takes the
+	// tempo at its start, the tempo map follows the first part
+	printMidiEvents(`T=120 T<60:2
+HL DE SA5 qwer VN
+VV HR DQ C2qe t
+DH qw VN
+y`)
+
+	// Output:
+	// 0 0 FF 51 03 07 A1 20
+	// 0 0 90 18 7F
+	// 0 0 91 3C 7F
+	// 0 0 91 40 7F
+	// 0 240 FF 51 03 08 B8 25
+	// 0 240 80 18 00
+	// 0 240 90 1A 7F
+	// 0 450 81 3C 00
+	// 0 450 81 40 00
+	// 0 450 91 43 7F
+	// 0 480 FF 51 03 0A 2C 2B
+	// 0 480 80 1A 00
+	// 0 480 90 1C 7F
+	// 0 720 FF 51 03 0C 35 00
+	// 0 720 80 1C 00
+	// 0 720 90 1D 7F
+	// 0 899 81 43 00
+	// 0 960 FF 51 03 0F 42 40
+	// 0 960 80 1D 00
+	// 0 960 90 18 7F
+	// 0 960 91 45 7F
+	// 0 1440 81 45 00
+	// 0 1920 80 18 00
+	// 0 1920 90 1A 7F
+	// 0 2880 80 1A 00
+	// 0 2880 FF 2F 00
+}
@@ -0,0 +1,224 @@
+package beep
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A key of beep notation with its arguments, like "DE", "SA9" or "q~+50"
+type notationToken struct {
+	text string
+	note bool // music note key
+}
+
+// Splits notation line into keys as played by Music.Play
+func notationTokens(line string) []notationToken {
+	keys := []rune(line)
+	var tokens []notationToken
+	for k := 0; k < len(keys); k++ {
+		key := keys[k]
+		start := k
+		note := false
+		switch {
+		case key == 'P': // detune
+			_, n := parseCents(keys[k+1:])
+			k += n
+		case key == 'U': // tuplet
+			_, _, n := parseTuplet(keys[k+1:])
+			k += n
+		case key == 'T' && k+1 < len(keys) && strings.ContainsRune("=<>", keys[k+1]): // beats per minute
+			_, _, _, n := parseTempo(keys[k+1:])
+			k += n
+		case key == '!': // dynamics
+			_, _, _, n := parseDynamics(keys[k+1:])
+			k += n
+		case key == '<': // chord symbol
+			end, found := chordSymbolEnd(keys, k)
+			k = end
+			if found && k+1 < len(keys) && strings.ContainsRune("'^-;", keys[k+1]) {
+				k++ // articulation
+			}
+		case key == 'X': // arpeggiator
+			_, _, _, _, n := parseArpeggio(keys[k+1:])
+			k += n
+		case key == 'M': // time signature
+			_, _, n := parseTimeSignature(keys[k+1:])
+			k += n
+		case strings.ContainsRune("RDHTAC", key):
+			k++
+		case key == 'S' || key == 'E': // sustain and effect type with level
+			k += 2
+			if key == 'E' && k < len(keys) && keys[k-1] == 'X' {
+				k--
+			}
+		case key == 'V': // voice, VG### is General MIDI program
+			k++
+			if k < len(keys) && keys[k] == 'G' {
+				k += 3
+			}
+		case unicode.IsUpper(key) || strings.ContainsRune("\t |/()_\\", key):
+		default:
+			note = true
+			if k+1 < len(keys) && keys[k+1] == '~' {
+				// cents offset
+				_, n := parseCents(keys[k+2:])
+				k += n + 1
+			}
+			if k+1 < len(keys) && strings.ContainsRune("'^-;", keys[k+1]) {
+				k++ // articulation
+			}
+		}
+		if k >= len(keys) {
+			k = len(keys) - 1
+		}
+		tokens = append(tokens, notationToken{text: string(keys[start : k+1]), note: note})
+	}
+	return tokens
+}
+
+// Returns notes and rests played before next dynamic marking of notation
+// line and amplitude level of the marking, 0 if line has no marking
+func hairpinSpan(line string) (int, int) {
+	steps, chord, grace := 0, 0, false
+	for _, token := range notationTokens(line) {
+		keys := []rune(token.text)
+		switch {
+		case keys[0] == '\\':
+			grace = true
+		case keys[0] == '<':
+			// chord symbol is a chord or a pattern of its notes
+			end := strings.Index(token.text, ">")
+			if end < 0 {
+				break
+			}
+			if c, err := parseChordSymbol(token.text[1:end]); err == nil && c.pattern != "" {
+				steps += c.size()
+			} else {
+				steps++
+			}
+		case token.note && grace:
+			grace = false // takes time of next note
+		case keys[0] == '!':
+			if hairpin, level, _, _ := parseDynamics(keys[1:]); hairpin == 0 && level > 0 {
+				return steps, level
+			}
+		case keys[0] == 'C' && len(keys) == 2 && unicode.IsDigit(keys[1]):
+			chord = int(keys[1] - '0')
+		case keys[0] == 'R' && len(keys) == 2:
+			steps++
+		case token.note:
+			// a chord is played as one note
+			if chord > 1 {
+				chord--
+				continue
+			}
+			chord = 0
+			steps++
+		}
+	}
+	return steps, 0
+}
+
+// Returns octave control of hand after notation line played from hand
+func notationHand(line string, hand rune) rune {
+	for _, token := range notationTokens(line) {
+		if len(token.text) == 2 && token.text[0] == 'H' && handKeyLevel(rune(token.text[1])) > 0 {
+			hand = rune(token.text[1])
+		}
+	}
+	return hand
+}
+
+// Notes of a line transposed out of range A0-C8
+type rangeError struct {
+	notes   []string
+	columns []int // columns of notes in line, starting from 1
+}
+
+func (e *rangeError) Error() string {
+	var notes []string
+	for i, note := range e.notes {
+		notes = append(notes, fmt.Sprintf("note %s at column %d", note, e.columns[i]))
+	}
+	return "transposed out of range: " + strings.Join(notes, ", ")
+}
+
+// Transposes notes of notation line by semitones, hand is the octave
+// control the line starts with. Octave controls are added for notes moved
+// to other octave groups and the line ends with the octave control it
+// would end with without transposing. Notes transposed out of range are
+// kept and returned as *rangeError.
+func transposeNotation(line string, semitones int, hand rune) (string, error) {
+	var buf strings.Builder
+	outHand := hand
+	inserted := false // octave control was added for a transposed note
+	control := ""     // octave control of line not yet followed by notes
+	column := 1
+	outOfRange := &rangeError{}
+	for _, token := range notationTokens(line) {
+		at := column
+		column += utf8.RuneCountInString(token.text)
+		if len(token.text) == 2 && token.text[0] == 'H' && handKeyLevel(rune(token.text[1])) > 0 {
+			hand = rune(token.text[1])
+			if inserted && handKeyLevel(outHand) == handKeyLevel(hand) {
+				inserted = false
+				continue // already added for a transposed note
+			}
+			buf.WriteString(control)
+			control = token.text
+			inserted = false
+			outHand = hand
+			continue
+		}
+		keys := []rune(token.text)
+		if keys[0] == '<' {
+			buf.WriteString(control + transposeChordSymbol(token.text, semitones))
+			control = ""
+			continue
+		}
+		number, found := keyMidiNoteMap[handKeyLevel(hand)+keys[0]]
+		if !token.note || !found || semitones == 0 {
+			buf.WriteString(control + token.text)
+			control = ""
+			continue
+		}
+		target := int(number) + semitones
+		name, found := "", false
+		if target >= 0 && target < 128 {
+			name, found = midiNoteMap[byte(target)]
+		}
+		if !found {
+			outOfRange.notes = append(outOfRange.notes, token.text)
+			outOfRange.columns = append(outOfRange.columns, at)
+			buf.WriteString(control + token.text)
+			control = ""
+			continue
+		}
+		if handKeyLevel(outHand) != handKeyLevel(rune(name[1])) {
+			outHand = rune(name[1])
+			inserted = true
+			control = "" // replaced by control of transposed note
+			buf.WriteString(name[:2])
+		}
+		buf.WriteString(control)
+		control = ""
+		buf.WriteByte(name[2])
+		buf.WriteString(string(keys[1:]))
+	}
+	buf.WriteString(control)
+	transposed := buf.String()
+	if outHand != hand {
+		// restore octave control before VN joining next line
+		end := len(strings.TrimRight(transposed, " \t"))
+		if strings.HasSuffix(transposed[:end], "VN") {
+			end -= 2
+		}
+		transposed = transposed[:end] + "H" + string(hand) + transposed[end:]
+	}
+	if len(outOfRange.notes) > 0 {
+		return transposed, outOfRange
+	}
+	return transposed, nil
+}
@@ -1,13 +1,11 @@
 package beep
 
 import (
-	"archive/zip"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 )
 
 // Piano voice
@@ -15,17 +13,17 @@ type Piano struct {
 	naturalVoice      bool
 	naturalVoiceFound bool
 	keyDefMap         map[rune][]int16 // default voice
-	keyNatMap         map[rune][]int16 // natural voice
+	natural           *voicePack       // natural voice
 	keyFreqMap        map[rune]float64
 	keyNoteMap        map[rune]string
 	noteKeyMap        map[string]rune
+	mutex             sync.Mutex
 }
 
 // NewPiano returns new piano voice
 func NewPiano() *Piano {
 	p := &Piano{
 		keyDefMap:  make(map[rune][]int16),
-		keyNatMap:  make(map[rune][]int16),
 		keyFreqMap: make(map[rune]float64),
 		keyNoteMap: make(map[rune]string),
 		noteKeyMap: make(map[string]rune),
@@ -33,28 +31,6 @@ func NewPiano() *Piano {
 
 	keys := "q2w3er5t6y7ui9o0p[=]azsxcfvgbnjmk,l."
 
-	octaveFreq0 := []float64{
-		// A0, B0b, B0
-		27.50, 29.13, 30.86, // octave 0
-	}
-	octaveFreqLeft := []float64{
-		// C1, Db1, D1, Eb1, E1, F1, Gb1, G1, Ab1, A1, Bb1, B1
-		32.70, 34.64, 36.70, 38.89, 41.20, 43.65, 46.24, 48.99, 51.91, 55.00, 58.27, 61.73, // 1
-		65.40, 69.29, 73.41, 77.78, 82.40, 87.30, 92.49, 97.99, 103.8, 110.0, 116.5, 123.4, // 2
-		130.8, 138.5, 146.8, 155.5, 164.8, 174.6, 185.0, 196.0, 207.6, 220.0, 233.0, 246.9, // 3
-	}
-	octaveFreqRight := []float64{
-		// C4, Db4, D4, Eb4, E4, F4, Gb4, G4, Ab4, A4, Bb4, B4
-		261.6, 277.1, 293.6, 311.1, 329.6, 349.2, 369.9, 392.0, 415.3, 440.0, 466.1, 493.8, // 4
-		523.2, 554.3, 587.3, 622.2, 659.2, 698.4, 739.9, 783.9, 830.6, 880.0, 932.3, 987.7, // 5
-		1046.5, 1108.7, 1174.6, 1244.5, 1318.5, 1396.9, 1479.9, 1567, 1661, 1760, 1864, 1975, // 6
-	}
-	octaveFreq78 := []float64{
-		// C7, Db7, D7, Eb7, E7, F7, Gb7, G7, Ab7, A7, Bb7, B7
-		2093, 2217.5, 2349.3, 2489, 2637, 2793, 2960, 3136, 3322.4, 3520, 3729.3, 3951.1, // 7
-		4186.00, // 8
-	}
-
 	noteNames := []string{
 		"A0", "Bb0", "B0",
 		"C1", "Db1", "D1", "Eb1", "E1", "F1", "Gb1", "G1", "Ab1", "A1", "Bb1", "B1",
@@ -69,96 +45,81 @@ func NewPiano() *Piano {
 
 	// initialize maps
 	ni := 0
-	for i, key := range keys[33:] { // actave 0
+	for _, key := range keys[33:] { // actave 0
 		keyID := 1000 + key
 		note := noteNames[ni]
-		p.keyFreqMap[keyID] = octaveFreq0[i]
 		p.keyNoteMap[keyID] = note
 		p.noteKeyMap[note] = keyID
 		ni++
 	}
-	for i, key := range keys { // actave 1, 2, 3
+	for _, key := range keys { // actave 1, 2, 3
 		keyID := 2000 + key
 		note := noteNames[ni]
-		p.keyFreqMap[keyID] = octaveFreqLeft[i]
 		p.keyNoteMap[keyID] = note
 		p.noteKeyMap[note] = keyID
 		ni++
 	}
-	for i, key := range keys { // actave 4, 5, 6
+	for _, key := range keys { // actave 4, 5, 6
 		keyID := 3000 + key
 		note := noteNames[ni]
-		p.keyFreqMap[keyID] = octaveFreqRight[i]
 		p.keyNoteMap[keyID] = note
 		p.noteKeyMap[note] = keyID
 		ni++
 	}
-	for i, key := range keys[:13] { // actave 7, 8
+	for _, key := range keys[:13] { // actave 7, 8
 		keyID := 4000 + key
 		note := noteNames[ni]
-		p.keyFreqMap[keyID] = octaveFreq78[i]
 		p.keyNoteMap[keyID] = note
 		p.noteKeyMap[note] = keyID
 		ni++
 	}
-	for key := range p.keyFreqMap {
-		// generate default piano voice
-		p.keyDefMap[key] = p.generateNote(key, wholeNote)
-	}
+	p.setTuning(nil)
 
 	// load natural voice file, if exists
 	filename := filepath.Join(HomeDir(), "voices", "piano.zip")
-	voiceFile, err := zip.OpenReader(filename)
+	natural, err := loadVoicePack(filename, p.noteKeyMap)
 	if err == nil {
 		// voice file exists
-		defer voiceFile.Close()
+		p.natural = natural
 		p.naturalVoice = true
-		for _, zfile := range voiceFile.File {
-			file, err := zfile.Open()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Unable to open file from zip:", zfile.Name)
-				continue
-			}
-			defer file.Close()
-			if !strings.HasSuffix(zfile.Name, ".wav") {
-				continue
-			}
-			p.naturalVoiceFound = true
-			noteName := strings.Split(filepath.Base(zfile.Name), ".")[0]
-			if key, found := p.noteKeyMap[noteName]; found {
-				var header WaveHeader
-				header.ReadHeader(file)
-				if header.SampleRate != 44100 || header.BitsPerSample != 16 {
-					fmt.Fprintln(os.Stderr, "Unsupported sample file:", zfile.Name)
-					continue
-				}
-				buf, err := ioutil.ReadAll(file)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, "Unable to read file from zip:", zfile.Name)
-					continue
-				}
-				rest := wholeNote - len(buf)/2
-				if rest > 0 {
-					// too short, sample should be a whole note
-					bufRest := make([]byte, rest*2)
-					buf = append(buf, bufRest...)
-				}
-				buf16 := byteToInt16Buf(buf)
-				if len(buf16) < wholeNote {
-					fmt.Fprintln(os.Stderr, "Sample note duration must be 90112 samples long.")
-				}
-				trimWave(buf16)
-				p.keyNatMap[key] = buf16
-			} else {
-				fmt.Fprintln(os.Stderr, "Unknown note name in voice file:", noteName)
-			}
-		}
+		p.naturalVoiceFound = natural.found()
+		// pitch-shift recorded notes to missing keys
+		natural.fill(p.keyNoteMap)
+	} else if !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Invalid voice file %s: %v\n", filename, err)
 	}
 
 	return p
 }
 
-func (p *Piano) generateNote(key rune, duration int) []int16 {
+// Tunes keys to tuning, nil is equal temperament with A4 at 440 hertz
+func (p *Piano) setTuning(tuning *Tuning) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.keyFreqMap = tuneKeys(p.keyNoteMap, tuning)
+	p.keyDefMap = make(map[rune][]int16)
+	if p.natural != nil {
+		p.natural.retune(tuning)
+	}
+}
+
+// Returns default voice of key, generated on first use
+func (p *Piano) defaultNote(key rune) ([]int16, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if buf, found := p.keyDefMap[key]; found {
+		return buf, true
+	}
+	if _, found := p.keyFreqMap[key]; !found {
+		return nil, false
+	}
+	buf := p.generateNote(key, nil, wholeNote)
+	p.keyDefMap[key] = buf
+	return buf, true
+}
+
+// Generates default voice of key, at the pitch of note if not nil
+func (p *Piano) generateNote(key rune, note *Note, duration int) []int16 {
 	// default voice
 	freq, found := p.keyFreqMap[key]
 	if !found {
@@ -175,7 +136,11 @@ func (p *Piano) generateNote(key rune, duration int) []int16 {
 	tick2 := tick1 * 3
 	tick3 := tick2 * 4
 	amp := SampleAmp16bit * 0.5
+	ratio := 1.0
 	for i := range buf {
+		if note != nil {
+			ratio = math.Pow(2, note.pitchCents(i)/1200)
+		}
 		sin0 := math.Sin(timer0)
 		sin1 := sin0 * math.Sin(timer1)
 		sin2 := sin1 * math.Sin(timer2)
@@ -185,10 +150,10 @@ func (p *Piano) generateNote(key rune, duration int) []int16 {
 		bar2 := bar0 * sin2 / 3 * sin0
 		bar3 := bar0 * sin3 / 4 * sin0
 		buf[i] = int16(bar0 + bar1 + bar2 + bar3)
-		timer0 += tick0
-		timer1 += tick1
-		timer2 += tick2
-		timer3 += tick3
+		timer0 += tick0 * ratio
+		timer1 += tick1 * ratio
+		timer2 += tick2 * ratio
+		timer3 += tick3 * ratio
 	}
 	trimWave(buf)
 	return buf
@@ -196,20 +161,40 @@ func (p *Piano) generateNote(key rune, duration int) []int16 {
 
 // GetNote prepares piano note wave buffer
 func (p *Piano) GetNote(note *Note, sustain *Sustain) (found bool) {
-	var bufNote []int16
+	var buf []int16
+	volume, amplitude := note.volume, note.amplitude
 	if p.naturalVoice {
-		bufNote, found = p.keyNatMap[note.key]
-	}
-	if !found {
-		bufNote, found = p.keyDefMap[note.key]
+		var gain float64
+		buf, gain, found = p.natural.sample(note)
+		if found {
+			// velocity layer sets amplitude
+			volume, amplitude = int(float64(volume)*gain), 0
+			if note.bent() {
+				buf = bendNote(buf, note)
+			}
+		}
 	}
 	if !found {
-		return
+		var bufNote []int16
+		bufNote, found = p.defaultNote(note.key)
+		if !found {
+			return
+		}
+		if note.bent() || note.samples > len(bufNote) {
+			// synthesize the exact pitch, or a note longer than whole note
+			duration := wholeNote
+			if note.samples > duration {
+				duration = note.samples
+			}
+			p.mutex.Lock()
+			buf = p.generateNote(note.key, note, duration)
+			p.mutex.Unlock()
+		} else {
+			buf = make([]int16, len(bufNote))
+			copy(buf, bufNote) // get a copy of the note
+		}
 	}
-
-	buf := make([]int16, len(bufNote))
-	copy(buf, bufNote) // get a copy of the note
-	applyNoteVolume(buf, note.volume, note.amplitude)
+	applyNoteVolume(buf, volume, amplitude)
 
 	// Sustain note
 	if note.duration == 'W' {
@@ -220,13 +205,16 @@ func (p *Piano) GetNote(note *Note, sustain *Sustain) (found bool) {
 			// sustain current note
 			copyBuffer(sustain.buf, buf[len(buf)/3:])
 		}
-	} else {
-		if p.NaturalVoice() {
-			// mix with previous sustain note
-			mixSoundWave(buf[:note.samples], sustain.buf)
-			// sustain current note
-			copyBuffer(sustain.buf, buf[note.samples:])
+	} else if p.NaturalVoice() {
+		// tied notes may be longer than sample
+		end := note.samples
+		if end > len(buf) {
+			end = len(buf)
 		}
+		// mix with previous sustain note
+		mixSoundWave(buf[:end], sustain.buf)
+		// sustain current note
+		copyBuffer(sustain.buf, buf[end:])
 	}
 
 	// measure note
@@ -240,7 +228,9 @@ func (p *Piano) GetNote(note *Note, sustain *Sustain) (found bool) {
 	trimWave(buf)
 
 	// release note
-	releaseNote(buf, 0, 0.99)
+	if !note.held() {
+		releaseNote(buf, 0, 0.99)
+	}
 
 	note.buf = buf
 	return
@@ -273,9 +263,15 @@ func (p *Piano) SustainNote(note *Note, sustain *Sustain) {
 
 	if p.naturalVoice {
 		attack := float64(9-sustain.attack) / 100 * 2
+		release := float64(1+sustain.release) / 10
+		if note.slurIn {
+			attack = 0.01
+		}
+		if note.held() {
+			release = 0.99
+		}
 		raiseNote(note.buf, attack)
 		raiseNote(sustain.buf, 0.01)
-		release := float64(1+sustain.release) / 10
 		releaseNote(note.buf, 0, release)
 		releaseNote(sustain.buf, 0, 0.5)
 		applyNoteVolume(sustain.buf, note.volume, sustain.sustain)
@@ -297,8 +293,16 @@ func (p *Piano) SustainNote(note *Note, sustain *Sustain) {
 	sustain.sustain = 4
 	attack := int(float64(buflen/200) * float64(sustain.attack))
 	decay := (buflen-attack)/10 + ((buflen - attack) / 20 * sustain.decay)
+	if note.slurIn {
+		// legato, start on sustain level
+		attack, decay = 0, 0
+	}
 	S := int16(volume64 / 10.0 * float64(sustain.sustain+1))
 	sustainCount := (buflen - attack - decay) / 2
+	if note.held() {
+		// legato, hold sustain level until next note
+		sustainCount = buflen - attack - decay
+	}
 	R := buflen - attack - decay - sustainCount
 	attack64 := float64(attack)
 	decay64 := float64(decay)
@@ -0,0 +1,113 @@
+package beep
+
+import (
+	"math"
+)
+
+// Half width of the Lanczos interpolation kernel in samples
+const resampleTaps = 6
+
+// Returns interpolated sample value at fractional position of buf.
+// Cutoff below 1.0 low-pass filters the kernel to prevent aliasing
+// when the sample is read faster than its sample rate.
+func sampleAt(buf []int16, pos, cutoff float64) float64 {
+	center := int(pos)
+	width := int(math.Ceil(resampleTaps / cutoff))
+	var sum, norm float64
+	for i := center - width + 1; i <= center+width; i++ {
+		if i < 0 || i >= len(buf) {
+			continue
+		}
+		w := lanczos((pos - float64(i)) * cutoff)
+		sum += float64(buf[i]) * w
+		norm += w
+	}
+	if norm == 0 {
+		return 0
+	}
+	return sum / norm
+}
+
+// Lanczos kernel values per 1/lanczosResolution sample
+const lanczosResolution = 512
+
+var lanczosTable = func() []float64 {
+	table := make([]float64, resampleTaps*lanczosResolution+1)
+	for i := range table {
+		x := float64(i) / lanczosResolution
+		if i == 0 {
+			table[i] = 1
+			continue
+		}
+		px := math.Pi * x
+		table[i] = resampleTaps * math.Sin(px) * math.Sin(px/resampleTaps) / (px * px)
+	}
+	return table
+}()
+
+// Lanczos windowed sinc kernel
+func lanczos(x float64) float64 {
+	if x < 0 {
+		x = -x
+	}
+	if x >= resampleTaps {
+		return 0
+	}
+	return lanczosTable[int(x*lanczosResolution+0.5)]
+}
+
+// Returns cutoff of the interpolation filter for reading samples with step
+func resampleCutoff(step float64) float64 {
+	if step > 1 {
+		return 1 / step
+	}
+	return 1
+}
+
+// Resamples buf by reading it with step, step 2.0 raises pitch by an octave.
+// Returned buffer has length samples.
+func resample(buf []int16, step float64, length int) []int16 {
+	out := make([]int16, length)
+	cutoff := resampleCutoff(step)
+	last := float64(len(buf) - 1)
+	pos := 0.0
+	for i := range out {
+		if pos > last {
+			break
+		}
+		bar := sampleAt(buf, pos, cutoff)
+		if bar > SampleAmp16bit {
+			bar = SampleAmp16bit
+		} else if bar < -SampleAmp16bit {
+			bar = -SampleAmp16bit
+		}
+		out[i] = int16(bar)
+		pos += step
+	}
+	return out
+}
+
+// Returns note buffer pitch-shifted by note cents and glide, keeping its length
+func bendNote(buf []int16, note *Note) []int16 {
+	if note.glide == 0 {
+		return resample(buf, math.Pow(2, note.cents/1200), len(buf))
+	}
+	out := make([]int16, len(buf))
+	last := float64(len(buf) - 1)
+	pos := 0.0
+	for i := range out {
+		if pos > last {
+			break
+		}
+		step := math.Pow(2, note.pitchCents(i)/1200)
+		bar := sampleAt(buf, pos, resampleCutoff(step))
+		if bar > SampleAmp16bit {
+			bar = SampleAmp16bit
+		} else if bar < -SampleAmp16bit {
+			bar = -SampleAmp16bit
+		}
+		out[i] = int16(bar)
+		pos += step
+	}
+	return out
+}
@@ -0,0 +1,29 @@
+package beep
+
+import (
+	"fmt"
+	"math"
+)
+
+func Example_resample() {
+	// 441 hertz sine wave pitch-shifted up a fifth and down an octave
+	buf := make([]int16, SampleRate)
+	for i := range buf {
+		buf[i] = int16(10000 * math.Sin(2*math.Pi*441*float64(i)/SampleRate64))
+	}
+	for _, semitones := range []int{7, -12} {
+		step := math.Pow(2, float64(semitones)/12)
+		shifted := resample(buf, step, SampleRate/2)
+		crossings := 0
+		for i := 1; i < len(shifted); i++ {
+			if shifted[i-1] < 0 && shifted[i] >= 0 {
+				crossings++
+			}
+		}
+		fmt.Printf("%+d semitones: %d hertz\n", semitones, crossings*2)
+	}
+
+	// Output:
+	// +7 semitones: 660 hertz
+	// -12 semitones: 220 hertz
+}
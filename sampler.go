@@ -0,0 +1,257 @@
+package beep
+
+import (
+	"math"
+)
+
+// Sampler voice plays instruments made of sample regions,
+// loaded from SFZ instrument definitions or SoundFont presets
+type Sampler struct {
+	Name    string
+	regions []*samplerRegion
+	tuning  *Tuning // nil is equal temperament with A4 at 440 hertz
+}
+
+// A sample mapped to a key and velocity range
+type samplerRegion struct {
+	sample     []int16 // mono 16-bit samples
+	sampleRate int
+	loKey      int // MIDI note numbers
+	hiKey      int
+	rootKey    int
+	loVel      int // 1-127
+	hiVel      int
+	keyTrack   float64 // cents per key
+	tune       float64 // cents
+	volume     float64 // dB
+	velTrack   float64 // 0-1, velocity to amplitude tracking
+	offset     int
+	loopMode   string // no_loop, one_shot, loop_continuous, loop_sustain
+	loopStart  int
+	loopEnd    int     // last sample of the loop, inclusive
+	attack     float64 // amp envelope in seconds
+	hold       float64
+	decay      float64
+	sustain    float64 // 0-1
+	release    float64
+}
+
+// Returns a region with SFZ default values
+func newSamplerRegion() *samplerRegion {
+	return &samplerRegion{
+		loKey:    0,
+		hiKey:    127,
+		rootKey:  60,
+		loVel:    1,
+		hiVel:    127,
+		keyTrack: 100,
+		velTrack: 1,
+		loopMode: "no_loop",
+		sustain:  1,
+		release:  0.001,
+	}
+}
+
+// Clamps offset and loop points of region into its sample
+func (r *samplerRegion) clamp() {
+	last := len(r.sample) - 1
+	if last < 0 {
+		last = 0
+	}
+	for _, point := range []*int{&r.offset, &r.loopStart, &r.loopEnd} {
+		if *point < 0 {
+			*point = 0
+		} else if *point > last {
+			*point = last
+		}
+	}
+}
+
+// Returns regions that play the MIDI note with velocity.
+// If no region covers the note, the nearest region is used and
+// its sample is pitch-shifted to fill the missing key.
+func (s *Sampler) findRegions(number, velocity int) []*samplerRegion {
+	var found []*samplerRegion
+	for _, r := range s.regions {
+		if number >= r.loKey && number <= r.hiKey &&
+			velocity >= r.loVel && velocity <= r.hiVel {
+			found = append(found, r)
+		}
+	}
+	if len(found) > 0 {
+		return found
+	}
+	var nearest *samplerRegion
+	distance := 0
+	for _, r := range s.regions {
+		d := 0
+		if number < r.loKey {
+			d = r.loKey - number
+		} else if number > r.hiKey {
+			d = number - r.hiKey
+		}
+		if velocity < r.loVel || velocity > r.hiVel {
+			d += 128 // prefer regions in velocity range
+		}
+		if nearest == nil || d < distance {
+			nearest = r
+			distance = d
+		}
+	}
+	if nearest != nil {
+		found = append(found, nearest)
+	}
+	return found
+}
+
+// GetNote renders note from sample regions
+func (s *Sampler) GetNote(note *Note, sustain *Sustain) bool {
+	number, found := keyMidiNoteMap[note.key]
+	if !found {
+		return false
+	}
+	velocity := noteVelocity(note)
+	regions := s.findRegions(int(number), velocity)
+	if len(regions) == 0 {
+		return false
+	}
+
+	detune := note.cents // cents from equal temperament
+	if s.tuning != nil {
+		detune += 1200 * math.Log2(s.tuning.detune(int(number)))
+	}
+
+	// render note and its release into sustain buffer
+	buf := make([]int16, note.samples+len(sustain.buf))
+	for _, r := range regions {
+		r.render(buf, note, int(number), velocity, detune)
+	}
+
+	// mix with previous sustain note
+	mixSoundWave(buf[:note.samples], sustain.buf)
+	// sustain current note
+	copyBuffer(sustain.buf, buf[note.samples:])
+
+	note.buf = buf[:note.samples]
+	return true
+}
+
+// Renders the region sample of note into buf, held for the note samples.
+// Detune in cents follows key tracking, so untracked drums keep their pitch.
+// Glide in cents from the previous note pitch fades out while the note is held.
+func (r *samplerRegion) render(buf []int16, note *Note, number, velocity int, detune float64) {
+	if len(r.sample) < 2 {
+		return
+	}
+	held, glide := note.samples, note.glide
+	volume := float64(note.volume) / SampleAmp16bit
+	cents := float64(number-r.rootKey)*r.keyTrack + r.tune + detune*r.keyTrack/100
+	step := math.Pow(2, cents/1200) * float64(r.sampleRate) / SampleRate64
+	vel := float64(velocity) / 127
+	gain := volume * (1 - r.velTrack*(1-vel*vel)) * math.Pow(10, r.volume/20)
+	looping := r.loopEnd > r.loopStart && r.loopEnd < len(r.sample) &&
+		(r.loopMode == "loop_continuous" || r.loopMode == "loop_sustain")
+	loopEnd := float64(r.loopEnd + 1)
+	loopSize := float64(r.loopEnd + 1 - r.loopStart)
+	glideLength := held
+	if r.loopMode == "one_shot" {
+		held = len(buf) // one shot samples ignore note length
+	}
+	cutoff := resampleCutoff(step)
+	baseStep := step
+	last := len(r.sample) - 1
+	pos := float64(r.offset)
+	for i := range buf {
+		if looping && pos >= loopEnd && (r.loopMode == "loop_continuous" || i < held) {
+			pos -= loopSize
+		}
+		index := int(pos)
+		if index >= last {
+			break
+		}
+		if glide != 0 && i <= glideLength {
+			cents := glide * (1 - float64(i)/float64(glideLength)) * r.keyTrack / 100
+			step = baseStep * math.Pow(2, cents/1200)
+			cutoff = resampleCutoff(step)
+		}
+		bar := sampleAt(r.sample, pos, cutoff)
+		bar = float64(buf[i]) + bar*gain*r.envelope(i, held, note.slurIn)
+		if bar > SampleAmp16bit {
+			bar = SampleAmp16bit
+		} else if bar < -SampleAmp16bit {
+			bar = -SampleAmp16bit
+		}
+		buf[i] = int16(bar)
+		pos += step
+	}
+}
+
+// Returns amplitude envelope level at sample i of a note held for the number of samples,
+// legato notes start without attack
+func (r *samplerRegion) envelope(i, held int, legato bool) float64 {
+	level := r.envelopeHeld(i, legato)
+	if i < held {
+		return level
+	}
+	release := r.release * SampleRate64
+	if release < 1 {
+		return 0
+	}
+	level = r.envelopeHeld(held, legato)
+	fade := 1 - float64(i-held)/release
+	if fade < 0 {
+		return 0
+	}
+	return level * fade
+}
+
+// Returns amplitude envelope level while the note is held
+func (r *samplerRegion) envelopeHeld(i int, legato bool) float64 {
+	t := float64(i) / SampleRate64
+	if t < r.attack && !legato {
+		return t / r.attack
+	}
+	t -= r.attack + r.hold
+	if t < 0 {
+		return 1
+	}
+	if t < r.decay {
+		return 1 - (1-r.sustain)*(t/r.decay)
+	}
+	return r.sustain
+}
+
+// SustainNote applies sustain settings to note, sampler notes are
+// shaped by region amplitude envelopes
+func (s *Sampler) SustainNote(note *Note, sustain *Sustain) {
+}
+
+// Sustain flag
+func (s *Sampler) Sustain() bool {
+	return true
+}
+
+// NaturalVoice flag
+func (s *Sampler) NaturalVoice() bool {
+	return true
+}
+
+// NaturalVoiceFound flag
+func (s *Sampler) NaturalVoiceFound() bool {
+	return len(s.regions) > 0
+}
+
+// ComputerVoice has no effect, sampler has no computer generated voice
+func (s *Sampler) ComputerVoice(enable bool) {
+}
+
+// Returns MIDI velocity 1-127 of the note
+func noteVelocity(note *Note) int {
+	if note.velocity > 0 {
+		return note.velocity
+	}
+	if note.amplitude > 0 {
+		return note.amplitude * 127 / 9
+	}
+	return 127
+}
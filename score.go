@@ -0,0 +1,506 @@
+package beep
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Score - lines of a music sheet in playing order, with repeats,
+// endings, jumps and sections expanded
+type Score struct {
+	Lines []ScoreLine
+	jump  bool // next line is played after a jump
+}
+
+// ScoreLine - a line of score and its position in the music sheet
+type ScoreLine struct {
+	Text    string
+	Number  int    // line number in sheet, starting from 1
+	Section string // name of played section, empty if none
+	Pass    int    // pass of repeat, starting from 1
+	File    string // name of included sheet, empty for the played sheet
+	jump    bool   // line is played after a jump
+}
+
+// String returns position of line like "line 12, section A, pass 2"
+func (l ScoreLine) String() string {
+	pos := l.location()
+	if len(l.Section) > 0 {
+		pos += ", section " + l.Section
+	}
+	if l.Pass > 1 {
+		pos += fmt.Sprintf(", pass %d", l.Pass)
+	}
+	return pos
+}
+
+// Returns sheet location of line like "line 12" or "line 3 of jingles"
+func (l ScoreLine) location() string {
+	if len(l.File) > 0 {
+		return fmt.Sprintf("line %d of %s", l.Number, l.File)
+	}
+	return fmt.Sprintf("line %d", l.Number)
+}
+
+// Lines played together, a line and the lines mixed by VN
+type scoreGroup struct {
+	lines       []ScoreLine
+	at          string // sheet location of first line
+	section     string // name of section, empty if none
+	repeatStart bool
+	repeatEnd   bool
+	volta       []int // numbers of ending, nil if not an ending
+	lastVolta   bool  // ending played after a D.C. or D.S. jump
+	passes      int   // highest ending number of repeat starting at group
+	segno       bool
+	coda        bool
+	toCoda      bool
+	fine        bool
+	jump        string   // D.C. or D.S.
+	jumpTo      string   // Fine or Coda, empty plays to the end
+	play        []string // names of sections to play
+}
+
+// ParseScore reads music sheet and expands repeats, endings, jumps,
+// sections, macros and included sheets into playing order. Included sheets
+// are found in dir, the sheets directory of home directory or built-in snippets.
+func ParseScore(reader *bufio.Reader, dir string) (*Score, error) {
+	var (
+		groups       []*scoreGroup
+		sections     = make(map[string][]*scoreGroup)
+		section      string // name of section being read
+		group        *scoreGroup
+		blockComment bool
+		playing      bool // sheet has play directive
+		macros       = make(map[string]*macro)
+		hands        partHands // octave controls of parts for transposing macros
+	)
+	sheets := &sheetReader{}
+	sheets.push(reader, nil, "", "", dir)
+	defer sheets.close()
+	for {
+		sheetLine, done := sheets.next()
+		if done {
+			break
+		}
+		line, at := sheetLine.Text, sheetLine.location()
+		if strings.HasPrefix(line, "#include") && !blockComment {
+			if err := sheets.include(line); err != nil {
+				return nil, fmt.Errorf("%s: %v", at, err)
+			}
+			continue
+		}
+		comment := strings.HasPrefix(line, "#") || blockComment
+		if m, found := parseMacroDefinition(line, at); found && !comment {
+			macros[m.name] = m
+			continue
+		}
+		if group == nil {
+			group = &scoreGroup{at: at}
+		}
+		if comment {
+			// comments are printed with next line
+			if strings.HasPrefix(line, "##") {
+				blockComment = !blockComment
+			}
+			group.lines = append(group.lines, sheetLine)
+			continue
+		}
+		notation, _, _ := parseScoreMarkers(line, &scoreGroup{})
+		hand := hands.next(strings.HasSuffix(notation, "VN"))
+		if strings.Contains(line, "@") {
+			expanded, err := expandMacros(line, macros, *hand, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", at, err)
+			}
+			line = expanded
+		}
+		*hand = notationHand(line, *hand)
+		text, directives, err := parseScoreMarkers(line, group)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", at, err)
+		}
+		for _, directive := range directives {
+			if name, found := cutPrefixFold(directive, "section "); found {
+				if _, found := sections[name]; found {
+					return nil, fmt.Errorf("%s: duplicate section %s", at, name)
+				}
+				section = name
+				sections[section] = nil
+				continue
+			}
+			if names, found := cutPrefixFold(directive, "play "); found {
+				group.play = strings.Fields(names)
+				playing = true
+				section = "" // play ends section
+				continue
+			}
+			if err := group.setDirective(directive); err != nil {
+				return nil, fmt.Errorf("%s: %v", at, err)
+			}
+		}
+		if len(text) > 0 || len(directives) == 0 {
+			sheetLine.Text = text
+			group.lines = append(group.lines, sheetLine)
+		}
+		if strings.HasSuffix(text, "VN") {
+			// next line is mixed with the line
+			continue
+		}
+		group.section = section
+		groups = append(groups, group)
+		if len(section) > 0 {
+			sections[section] = append(sections[section], group)
+		}
+		group = nil
+	}
+	if group != nil {
+		groups = append(groups, group)
+	}
+
+	main := groups
+	if playing {
+		// sections are played by play directives only
+		main = nil
+		for _, g := range groups {
+			if len(g.section) == 0 {
+				main = append(main, g)
+			}
+		}
+	}
+	markLastVolta(main)
+	for _, groups := range sections {
+		markLastVolta(groups)
+	}
+	score := &Score{}
+	err := score.playGroups(main, sections, nil)
+	return score, err
+}
+
+// Parses repeat signs and directives of line, returns line without them
+func parseScoreMarkers(line string, group *scoreGroup) (string, []string, error) {
+	var directives []string
+	for {
+		begin := strings.Index(line, "{")
+		if begin < 0 {
+			break
+		}
+		end := strings.Index(line[begin:], "}")
+		if end < 0 {
+			return "", nil, fmt.Errorf("missing } in %s", line)
+		}
+		directives = append(directives, strings.TrimSpace(line[begin+1:begin+end]))
+		line = line[:begin] + line[begin+end+1:]
+	}
+	line = strings.Trim(line, " \t")
+	if strings.HasPrefix(line, "|:") {
+		group.repeatStart = true
+		line = strings.TrimLeft(line[2:], " \t")
+	}
+	mixed := strings.HasSuffix(line, "VN")
+	line = strings.TrimRight(strings.TrimSuffix(line, "VN"), " \t")
+	if strings.HasSuffix(line, ":|") {
+		group.repeatEnd = true
+		line = strings.TrimRight(line[:len(line)-2], " \t")
+	}
+	if mixed {
+		line += "VN"
+	}
+	return line, directives, nil
+}
+
+// Sets group flags of directive like segno, D.C. al Fine or ending numbers 1,2
+func (g *scoreGroup) setDirective(directive string) error {
+	switch strings.ToLower(directive) {
+	case "segno":
+		g.segno = true
+	case "coda":
+		g.coda = true
+	case "to coda":
+		g.toCoda = true
+	case "fine":
+		g.fine = true
+	case "d.c.":
+		g.jump = "D.C."
+	case "d.s.":
+		g.jump = "D.S."
+	case "d.c. al fine":
+		g.jump, g.jumpTo = "D.C.", "Fine"
+	case "d.s. al fine":
+		g.jump, g.jumpTo = "D.S.", "Fine"
+	case "d.c. al coda":
+		g.jump, g.jumpTo = "D.C.", "Coda"
+	case "d.s. al coda":
+		g.jump, g.jumpTo = "D.S.", "Coda"
+	default:
+		for _, field := range strings.Split(directive, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 {
+				return fmt.Errorf("unknown directive {%s}", directive)
+			}
+			g.volta = append(g.volta, n)
+		}
+	}
+	return nil
+}
+
+// Marks endings with the last number of their repeat and repeat starts
+// with the number of passes of endings
+func markLastVolta(groups []*scoreGroup) {
+	maxVolta := make(map[int]int) // last ending number by repeat start
+	start := 0
+	for i, g := range groups {
+		if g.repeatStart {
+			start = i
+		}
+		for _, n := range g.volta {
+			if n > maxVolta[start] {
+				maxVolta[start] = n
+			}
+		}
+	}
+	start = 0
+	for i, g := range groups {
+		if g.repeatStart {
+			start = i
+		}
+		g.lastVolta = containsInt(g.volta, maxVolta[start])
+	}
+	for start, n := range maxVolta {
+		groups[start].passes = n
+	}
+}
+
+// Appends lines of groups in playing order, playing lists names of
+// sections being played to detect cycles
+func (s *Score) playGroups(groups []*scoreGroup, sections map[string][]*scoreGroup,
+	playing []string) error {
+	var (
+		pass   = 1
+		start  int  // index of repeat start
+		back   bool // jumped back to repeat start
+		jumped bool // D.C. or D.S. jump done
+		jumpTo string
+		taken  = make(map[int]int) // times repeat ends jumped back
+		end    = len(groups)       // index of last repeat end jumped back
+	)
+	for i := 0; i < len(groups); i++ {
+		g := groups[i]
+		if g.repeatStart && !back {
+			start, pass = i, 1
+		}
+		back = false
+		if len(g.volta) == 0 && i > end {
+			pass = 1 // repeat is done
+		}
+		if len(g.volta) > 0 {
+			if jumped && !g.lastVolta || !jumped && !containsInt(g.volta, pass) {
+				s.jump = true
+				continue // other ending
+			}
+		}
+		for _, line := range g.lines {
+			line.Section = g.section
+			line.Pass = pass
+			line.jump = s.jump
+			s.jump = false
+			s.Lines = append(s.Lines, line)
+		}
+		for _, name := range g.play {
+			for _, played := range playing {
+				if played == name {
+					return fmt.Errorf("%s: section %s plays itself", g.at, name)
+				}
+			}
+			lines, found := sections[name]
+			if !found {
+				return fmt.Errorf("%s: unknown section %s", g.at, name)
+			}
+			s.jump = true
+			if err := s.playGroups(lines, sections, append(playing, name)); err != nil {
+				return err
+			}
+			s.jump = true
+		}
+		if jumped && jumpTo == "Fine" && g.fine {
+			break
+		}
+		if jumped && jumpTo == "Coda" && g.toCoda {
+			jumpTo = ""
+			coda := -1
+			for j := i + 1; j < len(groups); j++ {
+				if groups[j].coda {
+					coda = j
+					break
+				}
+			}
+			if coda < 0 {
+				return fmt.Errorf("%s: to coda without coda", g.at)
+			}
+			i = coda - 1
+			s.jump = true
+			continue
+		}
+		passes := groups[start].passes // repeat is played until its last ending
+		if passes < 2 {
+			passes = 2
+		}
+		if g.repeatEnd && !jumped && taken[i] < passes-1 {
+			taken[i]++
+			end = i
+			i = start - 1
+			s.jump = true
+			pass++
+			back = true
+			continue
+		}
+		if len(g.jump) > 0 && !jumped {
+			jumped, jumpTo = true, g.jumpTo
+			target := 0
+			if g.jump == "D.S." {
+				target = -1
+				for j, segno := range groups {
+					if segno.segno {
+						target = j
+						break
+					}
+				}
+				if target < 0 {
+					return fmt.Errorf("%s: D.S. without segno", g.at)
+				}
+			}
+			i = target - 1
+			s.jump = true
+			pass = 1
+		}
+	}
+	return nil
+}
+
+// Returns true if values contains value
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns s without case insensitive prefix, false if s has no prefix
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(s[len(prefix):]), true
+}
+
+// Lines of a sheet and its included sheets
+type sheetReader struct {
+	sheets []*sheetFile // included sheets, the last sheet is being read
+}
+
+// A sheet being read
+type sheetFile struct {
+	reader *bufio.Reader
+	closer io.Closer
+	name   string // name of included sheet
+	path   string // file path or built-in snippet name
+	dir    string // directory of sheets included by the sheet
+	number int    // line number
+}
+
+// Reads sheet until its end, then continues the including sheet
+func (r *sheetReader) push(reader *bufio.Reader, closer io.Closer, name, path, dir string) {
+	r.sheets = append(r.sheets, &sheetFile{
+		reader: reader,
+		closer: closer,
+		name:   name,
+		path:   path,
+		dir:    dir,
+	})
+}
+
+// Returns next line of sheets, true if all sheets are read
+func (r *sheetReader) next() (ScoreLine, bool) {
+	for len(r.sheets) > 0 {
+		sheet := r.sheets[len(r.sheets)-1]
+		line, done := nextMusicLine(sheet.reader)
+		if !done {
+			sheet.number++
+			return ScoreLine{Text: line, Number: sheet.number, File: sheet.name}, false
+		}
+		if sheet.closer != nil {
+			sheet.closer.Close()
+		}
+		r.sheets = r.sheets[:len(r.sheets)-1]
+	}
+	return ScoreLine{}, true
+}
+
+// Includes sheet of include line like #include "jingles"
+func (r *sheetReader) include(line string) error {
+	name := strings.TrimSpace(strings.TrimPrefix(line, "#include"))
+	if len(name) < 3 || !strings.HasPrefix(name, `"`) || !strings.HasSuffix(name, `"`) {
+		return fmt.Errorf("invalid include, must be like #include \"file\"")
+	}
+	name = name[1 : len(name)-1]
+	dir := r.sheets[len(r.sheets)-1].dir
+	paths := []string{name}
+	if !filepath.IsAbs(name) {
+		paths = []string{
+			filepath.Join(dir, name),
+			filepath.Join(HomeDir(), "sheets", name),
+		}
+	}
+	var reader *bufio.Reader
+	var closer io.Closer
+	var path string
+	for _, filename := range paths {
+		file, err := os.Open(filename)
+		if err == nil {
+			reader, closer = bufio.NewReader(file), file
+			path, _ = filepath.Abs(filename)
+			dir = filepath.Dir(filename)
+			break
+		}
+	}
+	if reader == nil {
+		for _, snippet := range BuiltinSnippets {
+			if snippet.Name == name {
+				reader = bufio.NewReader(bytes.NewBufferString(snippet.Notation))
+				path = "builtin:" + name
+				break
+			}
+		}
+	}
+	if reader == nil {
+		return fmt.Errorf("included sheet %s is not found", name)
+	}
+	for _, sheet := range r.sheets {
+		if sheet.path == path {
+			if closer != nil {
+				closer.Close()
+			}
+			return fmt.Errorf("sheet %s includes itself", name)
+		}
+	}
+	r.push(reader, closer, name, path, dir)
+	return nil
+}
+
+// Closes included sheet files
+func (r *sheetReader) close() {
+	for _, sheet := range r.sheets {
+		if sheet.closer != nil {
+			sheet.closer.Close()
+		}
+	}
+	r.sheets = nil
+}
@@ -0,0 +1,111 @@
+package beep
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func ExampleParseScore() {
+	sheet := `|: q
+{1} w :|
+{2} e {fine}
+{to coda} r
+{D.C. al Coda}
+{coda} t`
+	score, err := ParseScore(bufio.NewReader(strings.NewReader(sheet)), "")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, line := range score.Lines {
+		fmt.Printf("%s (%v)\n", line.Text, line)
+	}
+
+	// first and second endings both repeat
+	sheet = `|: a
+{1,2} b :|
+{3} c`
+	score, _ = ParseScore(bufio.NewReader(strings.NewReader(sheet)), "")
+	for _, line := range score.Lines {
+		fmt.Print(line.Text, " ")
+	}
+	fmt.Println()
+
+	// Output:
+	// q (line 1)
+	// w (line 2)
+	// q (line 1, pass 2)
+	// e (line 3, pass 2)
+	// r (line 4)
+	// q (line 1)
+	// e (line 3)
+	// r (line 4)
+	// t (line 6)
+	// a b a b a c
+}
+
+func ExampleParseScore_sections() {
+	sheet := `{section A} q
+w
+{section B} e
+{play A B A}`
+	score, _ := ParseScore(bufio.NewReader(strings.NewReader(sheet)), "")
+	for _, line := range score.Lines {
+		fmt.Printf("%s (%v)\n", line.Text, line)
+	}
+
+	// Output:
+	// q (line 1, section A)
+	// w (line 2, section A)
+	// e (line 3, section B)
+	// q (line 1, section A)
+	// w (line 2, section A)
+}
+
+func ExampleParseScore_include() {
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "motif.txt"), []byte("@motif = qwe\nr\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "loop.txt"), []byte("#include \"loop.txt\"\n"), 0644)
+
+	for _, sheet := range []string{"#include \"motif.txt\"\n@motif", "#include \"jingles\"\n@ding", "#include \"loop.txt\""} {
+		score, err := ParseScore(bufio.NewReader(strings.NewReader(sheet)), dir)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		for _, line := range score.Lines {
+			fmt.Printf("%s (%v)\n", line.Text, line)
+		}
+	}
+
+	// Output:
+	// r (line 2 of motif.txt)
+	// qwe (line 2)
+	// # Alert jingles - play with @ding, @notify, @success, @failure or @alert (line 1 of jingles)
+	// HRDE i (line 2)
+	// line 1 of loop.txt: sheet loop.txt includes itself
+}
+
+func ExampleParseScore_macros() {
+	// macro of second part is transposed from octave control of the part
+	sheet := `@m = ,
+HLz VN
+@m+12 z
+HLz VN
+@m z`
+	score, _ := ParseScore(bufio.NewReader(strings.NewReader(sheet)), "")
+	for _, line := range score.Lines {
+		fmt.Println(line.Text)
+	}
+
+	// Output:
+	// HLzVN
+	// H7yHR z
+	// HLzVN
+	// , z
+}
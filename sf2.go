@@ -0,0 +1,333 @@
+package beep
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// SoundFont - SoundFont 2 instrument bank
+type SoundFont struct {
+	Name    string
+	Presets []*SoundFontPreset
+}
+
+// SoundFontPreset - SoundFont preset, played as a sampler voice
+type SoundFontPreset struct {
+	Name    string
+	Bank    int
+	Program int
+	Voice   *Sampler
+}
+
+// SoundFont generator operators
+const (
+	sf2GenStartOffset       = 0
+	sf2GenEndOffset         = 1
+	sf2GenStartLoopOffset   = 2
+	sf2GenEndLoopOffset     = 3
+	sf2GenStartCoarseOffset = 4
+	sf2GenEndCoarseOffset   = 12
+	sf2GenAttackVolEnv      = 34
+	sf2GenHoldVolEnv        = 35
+	sf2GenDecayVolEnv       = 36
+	sf2GenSustainVolEnv     = 37
+	sf2GenReleaseVolEnv     = 38
+	sf2GenInstrument        = 41
+	sf2GenKeyRange          = 43
+	sf2GenVelRange          = 44
+	sf2GenStartLoopCoarse   = 45
+	sf2GenInitAttenuation   = 48
+	sf2GenEndLoopCoarse     = 50
+	sf2GenCoarseTune        = 51
+	sf2GenFineTune          = 52
+	sf2GenSampleID          = 53
+	sf2GenSampleModes       = 54
+	sf2GenScaleTuning       = 56
+	sf2GenRootKey           = 58
+	sf2GenCount             = 61
+)
+
+// Generator values of a zone, set flags which generators are present
+type sf2Zone struct {
+	gen [sf2GenCount]int
+	set [sf2GenCount]bool
+}
+
+// SoundFont sample header
+type sf2Sample struct {
+	start      int
+	end        int
+	loopStart  int
+	loopEnd    int
+	sampleRate int
+	rootKey    int
+	correction int // cents
+}
+
+// LoadSoundFont reads a SoundFont 2 file
+func LoadSoundFont(filename string) (*SoundFont, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "sfbk" {
+		return nil, errors.New("not a SoundFont 2 file")
+	}
+	chunks := make(map[string][]byte)
+	readRiffChunks(data[12:], chunks)
+
+	smpl := chunks["smpl"]
+	if smpl == nil {
+		return nil, errors.New("SoundFont has no sample data")
+	}
+	pcm := make([]int16, len(smpl)/2)
+	for i := range pcm {
+		pcm[i] = int16(binary.LittleEndian.Uint16(smpl[i*2:]))
+	}
+
+	for _, name := range []string{"phdr", "pbag", "pgen", "inst", "ibag", "igen", "shdr"} {
+		if chunks[name] == nil {
+			return nil, fmt.Errorf("SoundFont has no '%s' chunk", name)
+		}
+	}
+	instruments := sf2Zones(chunks["inst"], 22, 20, chunks["ibag"], chunks["igen"])
+	presetZones := sf2Zones(chunks["phdr"], 38, 24, chunks["pbag"], chunks["pgen"])
+
+	var samples []*sf2Sample
+	shdr := chunks["shdr"]
+	for pos := 0; pos+46 <= len(shdr); pos += 46 {
+		h := shdr[pos : pos+46]
+		s := &sf2Sample{
+			start:      int(binary.LittleEndian.Uint32(h[20:])),
+			end:        int(binary.LittleEndian.Uint32(h[24:])),
+			loopStart:  int(binary.LittleEndian.Uint32(h[28:])),
+			loopEnd:    int(binary.LittleEndian.Uint32(h[32:])),
+			sampleRate: int(binary.LittleEndian.Uint32(h[36:])),
+			rootKey:    int(h[40]),
+			correction: int(int8(h[41])),
+		}
+		samples = append(samples, s)
+	}
+
+	font := &SoundFont{
+		Name: strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)),
+	}
+	if info := chunks["INAM"]; info != nil {
+		font.Name = sf2String(info)
+	}
+	phdr := chunks["phdr"]
+	for i, zones := range presetZones {
+		h := phdr[i*38 : i*38+38]
+		preset := &SoundFontPreset{
+			Name:    sf2String(h[:20]),
+			Program: int(binary.LittleEndian.Uint16(h[20:])),
+			Bank:    int(binary.LittleEndian.Uint16(h[22:])),
+			Voice:   &Sampler{},
+		}
+		preset.Voice.Name = preset.Name
+		var global *sf2Zone
+		for z, zone := range zones {
+			if !zone.set[sf2GenInstrument] {
+				if z == 0 {
+					global = zone
+				}
+				continue
+			}
+			inst := zone.gen[sf2GenInstrument]
+			if inst >= len(instruments) {
+				continue
+			}
+			presetZone := mergeSf2Zones(global, zone)
+			var instGlobal *sf2Zone
+			for iz, instZone := range instruments[inst] {
+				if !instZone.set[sf2GenSampleID] {
+					if iz == 0 {
+						instGlobal = instZone
+					}
+					continue
+				}
+				sampleID := instZone.gen[sf2GenSampleID]
+				if sampleID >= len(samples) {
+					continue
+				}
+				r := newSf2Region(mergeSf2Zones(instGlobal, instZone), presetZone, samples[sampleID], pcm)
+				if r != nil {
+					preset.Voice.regions = append(preset.Voice.regions, r)
+				}
+			}
+		}
+		if len(preset.Voice.regions) > 0 {
+			font.Presets = append(font.Presets, preset)
+		}
+	}
+	if len(font.Presets) == 0 {
+		return nil, errors.New("SoundFont has no presets")
+	}
+	return font, nil
+}
+
+// Preset returns the sampler voice of the bank and program, nil if not found
+func (f *SoundFont) Preset(bank, program int) *Sampler {
+	for _, preset := range f.Presets {
+		if preset.Bank == bank && preset.Program == program {
+			return preset.Voice
+		}
+	}
+	return nil
+}
+
+// Reads RIFF sub chunks into map, LIST chunks are flattened
+func readRiffChunks(data []byte, chunks map[string][]byte) {
+	for pos := 0; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		start := pos + 8
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		if id == "LIST" && end-start >= 4 {
+			readRiffChunks(data[start+4:end], chunks)
+		} else {
+			chunks[id] = data[start:end]
+		}
+		pos = start + size + size%2
+	}
+}
+
+// Returns zones of presets or instruments from header, bag and generator chunks.
+// The last header is a terminal record.
+func sf2Zones(headers []byte, headerSize, bagOffset int, bags, gens []byte) [][]*sf2Zone {
+	var result [][]*sf2Zone
+	count := len(headers)/headerSize - 1
+	bagIndex := func(i int) int {
+		return int(binary.LittleEndian.Uint16(headers[i*headerSize+bagOffset:]))
+	}
+	genIndex := func(bag int) int {
+		if bag*4+2 > len(bags) {
+			return len(gens) / 4
+		}
+		return int(binary.LittleEndian.Uint16(bags[bag*4:]))
+	}
+	for i := 0; i < count; i++ {
+		var zones []*sf2Zone
+		for bag := bagIndex(i); bag < bagIndex(i+1); bag++ {
+			zone := &sf2Zone{}
+			zone.gen[sf2GenKeyRange] = 127 << 8
+			zone.gen[sf2GenVelRange] = 127 << 8
+			for g := genIndex(bag); g < genIndex(bag+1) && g*4+4 <= len(gens); g++ {
+				oper := int(binary.LittleEndian.Uint16(gens[g*4:]))
+				if oper >= sf2GenCount {
+					continue
+				}
+				if oper == sf2GenKeyRange || oper == sf2GenVelRange {
+					// low and high bytes
+					zone.gen[oper] = int(gens[g*4+2]) | int(gens[g*4+3])<<8
+				} else {
+					zone.gen[oper] = int(int16(binary.LittleEndian.Uint16(gens[g*4+2:])))
+				}
+				zone.set[oper] = true
+			}
+			zones = append(zones, zone)
+		}
+		result = append(result, zones)
+	}
+	return result
+}
+
+// Returns a zone with global zone generators overridden by local zone
+func mergeSf2Zones(global, local *sf2Zone) *sf2Zone {
+	if global == nil {
+		return local
+	}
+	zone := *global
+	for i, set := range local.set {
+		if set {
+			zone.gen[i] = local.gen[i]
+			zone.set[i] = true
+		}
+	}
+	return &zone
+}
+
+// Returns a sampler region from instrument zone, preset zone generators are added to it
+func newSf2Region(zone, preset *sf2Zone, sample *sf2Sample, pcm []int16) *samplerRegion {
+	gen := func(oper, value int) int {
+		if zone.set[oper] {
+			value = zone.gen[oper]
+		}
+		if preset.set[oper] {
+			value += preset.gen[oper]
+		}
+		return value
+	}
+	start := sample.start + gen(sf2GenStartOffset, 0) + gen(sf2GenStartCoarseOffset, 0)*32768
+	end := sample.end + gen(sf2GenEndOffset, 0) + gen(sf2GenEndCoarseOffset, 0)*32768
+	if start < 0 || end > len(pcm) || end-start < 2 {
+		return nil
+	}
+	r := newSamplerRegion()
+	r.sample = pcm[start:end]
+	r.sampleRate = sample.sampleRate
+	r.rootKey = sample.rootKey
+	if zone.set[sf2GenRootKey] && zone.gen[sf2GenRootKey] >= 0 {
+		r.rootKey = zone.gen[sf2GenRootKey]
+	}
+	r.loKey, r.hiKey = sf2Range(zone.gen[sf2GenKeyRange], preset.gen[sf2GenKeyRange])
+	r.loVel, r.hiVel = sf2Range(zone.gen[sf2GenVelRange], preset.gen[sf2GenVelRange])
+	if r.loVel < 1 {
+		r.loVel = 1
+	}
+	r.keyTrack = float64(gen(sf2GenScaleTuning, 100))
+	r.tune = float64(gen(sf2GenCoarseTune, 0)*100+gen(sf2GenFineTune, 0)) + float64(sample.correction)
+	r.volume = -float64(gen(sf2GenInitAttenuation, 0)) / 10
+	switch gen(sf2GenSampleModes, 0) & 3 {
+	case 1:
+		r.loopMode = "loop_continuous"
+	case 3:
+		r.loopMode = "loop_sustain"
+	}
+	r.loopStart = sample.loopStart + gen(sf2GenStartLoopOffset, 0) + gen(sf2GenStartLoopCoarse, 0)*32768 - start
+	r.loopEnd = sample.loopEnd + gen(sf2GenEndLoopOffset, 0) + gen(sf2GenEndLoopCoarse, 0)*32768 - start - 1
+	r.attack = timecentsToSeconds(gen(sf2GenAttackVolEnv, -12000))
+	r.hold = timecentsToSeconds(gen(sf2GenHoldVolEnv, -12000))
+	r.decay = timecentsToSeconds(gen(sf2GenDecayVolEnv, -12000))
+	r.release = timecentsToSeconds(gen(sf2GenReleaseVolEnv, -12000))
+	// sustain is attenuation in centibels
+	r.sustain = math.Pow(10, -float64(gen(sf2GenSustainVolEnv, 0))/200)
+	r.clamp()
+	return r
+}
+
+// Returns intersection of instrument and preset ranges
+func sf2Range(inst, preset int) (int, int) {
+	lo, hi := inst&0xFF, inst>>8
+	if preset&0xFF > lo {
+		lo = preset & 0xFF
+	}
+	if preset>>8 < hi {
+		hi = preset >> 8
+	}
+	return lo, hi
+}
+
+// Converts SoundFont timecents to seconds
+func timecentsToSeconds(timecents int) float64 {
+	if timecents <= -12000 {
+		return 0.001
+	}
+	return math.Pow(2, float64(timecents)/1200)
+}
+
+// Returns zero terminated string
+func sf2String(buf []byte) string {
+	if i := strings.IndexByte(string(buf), 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return strings.TrimSpace(string(buf))
+}
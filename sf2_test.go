@@ -0,0 +1,73 @@
+package beep
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Returns RIFF chunk of id with data
+func riffChunk(id string, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	buf := append([]byte(id), make([]byte, 4)...)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(body)))
+	return append(buf, body...)
+}
+
+// Returns little endian bytes of values, strings are zero padded to 20 bytes
+func sf2Record(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, value := range values {
+		if name, ok := value.(string); ok {
+			value = []byte(name + string(make([]byte, 20-len(name))))
+		}
+		binary.Write(&buf, binary.LittleEndian, value)
+	}
+	return buf.Bytes()
+}
+
+func ExampleLoadSoundFont() {
+	pcm := make([]byte, 2000)
+	font := riffChunk("RIFF", []byte("sfbk"),
+		riffChunk("LIST", []byte("INFO"), riffChunk("INAM", []byte("Test Font\x00"))),
+		riffChunk("LIST", []byte("sdta"), riffChunk("smpl", pcm)),
+		riffChunk("LIST", []byte("pdta"),
+			riffChunk("phdr", sf2Record("Piano", uint16(0), uint16(0), uint16(0), make([]byte, 12)),
+				sf2Record("EOP", uint16(0), uint16(0), uint16(1), make([]byte, 12))),
+			riffChunk("pbag", sf2Record(uint16(0), uint16(0), uint16(1), uint16(0))),
+			riffChunk("pgen", sf2Record(uint16(sf2GenInstrument), int16(0), uint32(0))),
+			riffChunk("inst", sf2Record("Piano", uint16(0)), sf2Record("EOI", uint16(1))),
+			riffChunk("ibag", sf2Record(uint16(0), uint16(0), uint16(4), uint16(0))),
+			riffChunk("igen", sf2Record(
+				uint16(sf2GenKeyRange), []byte{60, 72},
+				uint16(sf2GenSampleModes), int16(1),
+				uint16(sf2GenStartLoopOffset), int16(-500), // before sample start
+				uint16(sf2GenSampleID), int16(0),
+				uint32(0))),
+			riffChunk("shdr", sf2Record("C4", uint32(0), uint32(1000), uint32(100), uint32(2000),
+				uint32(SampleRate), uint8(60), int8(-3), uint16(0), uint16(1)),
+				sf2Record("EOS", make([]byte, 26))),
+		),
+	)
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.sf2")
+	ioutil.WriteFile(filename, font, 0644)
+
+	sf, err := LoadSoundFont(filename)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(sf.Name, len(sf.Presets), sf.Presets[0].Name)
+	for _, r := range sf.Preset(0, 0).regions {
+		fmt.Println(r.loKey, r.hiKey, r.rootKey, r.tune, r.loopMode, r.loopStart, r.loopEnd, len(r.sample))
+	}
+
+	// Output:
+	// Test Font 1 Piano
+	// 60 72 60 -3 loop_continuous 0 999 1000
+}
@@ -0,0 +1,266 @@
+package beep
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches SFZ headers like <region> and opcodes like lokey=
+var sfzTokenRegexp = regexp.MustCompile(`<(\w+)>|([A-Za-z0-9_]+)=`)
+
+// Semitones of note names used in SFZ files
+var sfzNoteNames = map[byte]int{
+	'c': 0, 'd': 2, 'e': 4, 'f': 5, 'g': 7, 'a': 9, 'b': 11,
+}
+
+// LoadSfz loads an SFZ instrument definition and its WAV samples as a voice
+func LoadSfz(filename string) (*Sampler, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sampler := &Sampler{
+		Name: strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)),
+	}
+	samples := make(map[string]*WaveData)
+	dir := filepath.Dir(filename)
+
+	var (
+		header  string
+		control = make(map[string]string)
+		global  = make(map[string]string)
+		master  = make(map[string]string)
+		group   = make(map[string]string)
+		region  map[string]string
+	)
+	addRegion := func() error {
+		if region == nil {
+			return nil
+		}
+		opcodes := make(map[string]string)
+		for _, level := range []map[string]string{global, master, group, region} {
+			for name, value := range level {
+				opcodes[name] = value
+			}
+		}
+		region = nil
+		if opcodes["trigger"] == "release" || len(opcodes["sample"]) == 0 {
+			return nil // release triggers are not supported
+		}
+		name := strings.Replace(opcodes["sample"], "\\", "/", -1)
+		path := filepath.Join(dir, filepath.FromSlash(control["default_path"]), filepath.FromSlash(name))
+		wave, found := samples[path]
+		if !found {
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			wave, err = DecodeWave(buf)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			samples[path] = wave
+		}
+		r, err := newSfzRegion(opcodes, wave)
+		if err != nil {
+			return err
+		}
+		sampler.regions = append(sampler.regions, r)
+		return nil
+	}
+
+	text := stripSfzComments(string(data))
+	tokens := sfzTokenRegexp.FindAllStringSubmatchIndex(text, -1)
+	for i, token := range tokens {
+		if token[2] >= 0 {
+			// header
+			if err := addRegion(); err != nil {
+				return nil, err
+			}
+			header = text[token[2]:token[3]]
+			switch header {
+			case "global":
+				global = make(map[string]string)
+			case "master":
+				master = make(map[string]string)
+				group = make(map[string]string)
+			case "group":
+				group = make(map[string]string)
+			case "region":
+				region = make(map[string]string)
+			}
+			continue
+		}
+		// opcode value ends at the next token
+		end := len(text)
+		if i+1 < len(tokens) {
+			end = tokens[i+1][0]
+		}
+		name := text[token[4]:token[5]]
+		value := strings.TrimSpace(text[token[1]:end])
+		switch header {
+		case "control":
+			control[name] = value
+		case "global":
+			global[name] = value
+		case "master":
+			master[name] = value
+		case "group":
+			group[name] = value
+		case "region":
+			region[name] = value
+		}
+	}
+	if err := addRegion(); err != nil {
+		return nil, err
+	}
+	if len(sampler.regions) == 0 {
+		return nil, fmt.Errorf("no regions found in %s", filename)
+	}
+	return sampler, nil
+}
+
+// Returns new region from SFZ opcodes
+func newSfzRegion(opcodes map[string]string, wave *WaveData) (*samplerRegion, error) {
+	r := newSamplerRegion()
+	r.sample = wave.Samples
+	r.sampleRate = wave.SampleRate
+	if wave.LoopEnd > wave.LoopStart {
+		// loop points stored in the sample
+		r.loopStart = wave.LoopStart
+		r.loopEnd = wave.LoopEnd
+		r.loopMode = "loop_continuous"
+	}
+	if key, found := opcodes["key"]; found {
+		// key sets the key range and root key, overridden by other opcodes
+		number, err := sfzKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SFZ opcode value: key=%s", key)
+		}
+		r.loKey = number
+		r.hiKey = number
+		r.rootKey = number
+	}
+	for name, value := range opcodes {
+		var err error
+		switch name {
+		case "lokey":
+			r.loKey, err = sfzKey(value)
+		case "hikey":
+			r.hiKey, err = sfzKey(value)
+		case "pitch_keycenter":
+			r.rootKey, err = sfzKey(value)
+		case "lovel":
+			r.loVel, err = strconv.Atoi(value)
+		case "hivel":
+			r.hiVel, err = strconv.Atoi(value)
+		case "pitch_keytrack":
+			r.keyTrack, err = strconv.ParseFloat(value, 64)
+		case "tune":
+			r.tune, err = strconv.ParseFloat(value, 64)
+		case "volume":
+			r.volume, err = strconv.ParseFloat(value, 64)
+		case "amp_veltrack":
+			r.velTrack, err = strconv.ParseFloat(value, 64)
+			r.velTrack /= 100
+		case "offset":
+			r.offset, err = strconv.Atoi(value)
+			if r.offset < 0 {
+				err = fmt.Errorf("negative offset")
+			}
+		case "loop_mode", "loopmode":
+			r.loopMode = value
+		case "loop_start", "loopstart":
+			r.loopStart, err = strconv.Atoi(value)
+		case "loop_end", "loopend":
+			r.loopEnd, err = strconv.Atoi(value)
+		case "ampeg_attack":
+			r.attack, err = strconv.ParseFloat(value, 64)
+		case "ampeg_hold":
+			r.hold, err = strconv.ParseFloat(value, 64)
+		case "ampeg_decay":
+			r.decay, err = strconv.ParseFloat(value, 64)
+		case "ampeg_sustain":
+			r.sustain, err = strconv.ParseFloat(value, 64)
+			r.sustain /= 100
+		case "ampeg_release":
+			r.release, err = strconv.ParseFloat(value, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SFZ opcode value: %s=%s", name, value)
+		}
+	}
+	if _, found := opcodes["pitch_keycenter"]; !found {
+		if _, found := opcodes["key"]; !found && wave.RootKey > 0 {
+			r.rootKey = wave.RootKey
+		}
+	}
+	if transpose, found := opcodes["transpose"]; found {
+		semitones, err := strconv.Atoi(transpose)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SFZ opcode value: transpose=%s", transpose)
+		}
+		r.tune += float64(semitones) * 100
+	}
+	r.clamp()
+	return r, nil
+}
+
+// Parses an SFZ key, a MIDI note number or a note name like c#4
+func sfzKey(value string) (int, error) {
+	if number, err := strconv.Atoi(value); err == nil {
+		return number, nil
+	}
+	name := strings.ToLower(value)
+	if len(name) < 2 {
+		return 0, fmt.Errorf("invalid key: %s", value)
+	}
+	semitone, found := sfzNoteNames[name[0]]
+	if !found {
+		return 0, fmt.Errorf("invalid key: %s", value)
+	}
+	name = name[1:]
+	if name[0] == '#' {
+		semitone++
+		name = name[1:]
+	} else if name[0] == 'b' && len(name) > 1 {
+		semitone--
+		name = name[1:]
+	}
+	octave, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, fmt.Errorf("invalid key: %s", value)
+	}
+	return (octave+1)*12 + semitone, nil // c4 is 60
+}
+
+// Removes // line comments and /* */ block comments
+func stripSfzComments(text string) string {
+	var lines []string
+	for {
+		start := strings.Index(text, "/*")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start:], "*/")
+		if end < 0 {
+			text = text[:start]
+			break
+		}
+		text = text[:start] + " " + text[start+end+2:]
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue // preprocessor directives are not supported
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
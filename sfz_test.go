@@ -0,0 +1,53 @@
+package beep
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func Example_sfzKey() {
+	for _, name := range []string{"c4", "C#4", "db4", "a0", "60"} {
+		key, _ := sfzKey(name)
+		fmt.Print(key, " ")
+	}
+
+	// Output:
+	// 60 61 61 21 60
+}
+
+func ExampleLoadSfz() {
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+	var wav bytes.Buffer
+	NewWaveHeader(1, SampleRate, 16, 200).WriteHeader(&wav)
+	wav.Write(int16ToByteBuf(make([]int16, 100)))
+	ioutil.WriteFile(filepath.Join(dir, "c4.wav"), wav.Bytes(), 0644)
+
+	for _, sfz := range []string{
+		"<group> volume=-3 // piano\n<region> sample=c4.wav lokey=c4 hikey=b4 pitch_keycenter=60 offset=10\n<region> sample=c4.wav key=72 tune=-5",
+		"<region> sample=c4.wav offset=-10",
+		"<region> sample=missing.wav",
+	} {
+		filename := filepath.Join(dir, "piano.sfz")
+		ioutil.WriteFile(filename, []byte(sfz), 0644)
+		sampler, err := LoadSfz(filename)
+		if err != nil {
+			fmt.Println(filepath.Base(err.Error()))
+			continue
+		}
+		fmt.Println(sampler.Name)
+		for _, r := range sampler.regions {
+			fmt.Println(r.loKey, r.hiKey, r.rootKey, r.tune, r.volume, r.offset, len(r.sample))
+		}
+	}
+
+	// Output:
+	// piano
+	// 60 71 60 0 -3 10 100
+	// 72 72 72 -5 -3 0 100
+	// invalid SFZ opcode value: offset=-10
+	// missing.wav: no such file or directory
+}
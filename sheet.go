@@ -209,3 +209,17 @@ A4HLDW C2ci     |C2n[     |C2vo     |C2bp              |C2,z|
 `,
 	},
 }
+
+// BuiltinSnippets stores built-in sheets for #include, like #include "jingles"
+var BuiltinSnippets = []*Sheet{
+	{
+		Name: "jingles",
+		Notation: `# Alert jingles - play with @ding, @notify, @success, @failure or @alert
+@ding = HRDE i
+@notify = HRDS q e DE t
+@success = HRDS q e t DQ i
+@failure = HRDE t 5 DQ e
+@alert = HRDS i t i t i t
+`,
+	},
+}
@@ -0,0 +1,179 @@
+package beep
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Octave controls of parts while reading lines of a sheet in order,
+// lines joined by VN are parts keeping their own octave control
+type partHands struct {
+	hands  []rune // octave control of each part
+	part   int    // part of current line
+	system rune   // octave control of first part at start of system
+	mixed  bool   // previous line ends with VN
+}
+
+// Returns octave control of the part of next line, mixed is true if
+// the line ends with VN
+func (p *partHands) next(mixed bool) *rune {
+	if len(p.hands) == 0 {
+		p.hands = []rune{'R'}
+	}
+	p.part++
+	if !p.mixed {
+		p.part = 0
+		p.system = p.hands[0]
+	}
+	if p.part == len(p.hands) {
+		p.hands = append(p.hands, p.system)
+	}
+	p.mixed = mixed
+	return &p.hands[p.part]
+}
+
+// Transpose transposes notes of score lines by semitones. Notes moved to
+// other octave groups get octave controls and all notes transposed out
+// of range A0-C8 are reported.
+func (s *Score) Transpose(semitones int) error {
+	var (
+		hands        partHands
+		blockComment bool
+		errs         []string
+	)
+	for i, line := range s.Lines {
+		text := line.Text
+		if strings.HasPrefix(text, "#") || blockComment {
+			if strings.HasPrefix(text, "##") {
+				blockComment = !blockComment
+			}
+			continue
+		}
+		hand := hands.next(strings.HasSuffix(text, "VN"))
+		transposed, err := transposeNotation(text, semitones, *hand)
+		*hand = notationHand(text, *hand)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", line.location(), err))
+			continue
+		}
+		s.Lines[i].Text = transposed
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// TransposeSheet transposes notes of music sheet by semitones keeping
+// comments, directives, bars and spacing. Macro definitions are kept and
+// macros are played transposed, included sheets are not transposed.
+// Chord symbols are renamed and get an octave option when their root
+// moves past C.
+func TransposeSheet(sheet string, semitones int) (string, error) {
+	var (
+		hands        partHands
+		blockComment bool
+		macros       = make(map[string]*macro)
+		errs         []string
+	)
+	lines := strings.Split(sheet, "\n")
+	for i, line := range lines {
+		text := strings.TrimSpace(line)
+		at := fmt.Sprintf("line %d", i+1)
+		if strings.HasPrefix(text, "#") || blockComment {
+			if strings.HasPrefix(text, "##") {
+				blockComment = !blockComment
+			}
+			continue
+		}
+		if m, found := parseMacroDefinition(text, at); found {
+			macros[m.name] = m
+			continue
+		}
+		notation, _, _ := parseScoreMarkers(text, &scoreGroup{})
+		hand := hands.next(strings.HasSuffix(notation, "VN"))
+		transposed, err := transposeSheetLine(line, semitones, hand, macros)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", at, err))
+			continue
+		}
+		lines[i] = transposed
+	}
+	if len(errs) > 0 {
+		return strings.Join(lines, "\n"), fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Transposes notes of sheet line played from hand, directives like {segno}
+// are kept and macro uses like @bass+2 are played transposed. Hand is
+// changed to octave control after the line.
+func transposeSheetLine(line string, semitones int, hand *rune, macros map[string]*macro) (string, error) {
+	var buf strings.Builder
+	outOfRange := &rangeError{}
+	column := 0 // column of notation before line
+	for len(line) > 0 {
+		at := strings.IndexAny(line, "{@")
+		if at < 0 {
+			at = len(line)
+		}
+		transposed, err := transposeNotation(line[:at], semitones, *hand)
+		if e, ok := err.(*rangeError); ok {
+			// notes out of range in all of the line
+			outOfRange.notes = append(outOfRange.notes, e.notes...)
+			for _, c := range e.columns {
+				outOfRange.columns = append(outOfRange.columns, column+c)
+			}
+		} else if err != nil {
+			return "", err
+		}
+		buf.WriteString(transposed)
+		*hand = notationHand(line[:at], *hand)
+		column += utf8.RuneCountInString(line[:at])
+		line = line[at:]
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == '{' {
+			end := strings.Index(line, "}")
+			if end < 0 {
+				end = len(line) - 1
+			}
+			buf.WriteString(line[:end+1])
+			column += utf8.RuneCountInString(line[:end+1])
+			line = line[end+1:]
+			continue
+		}
+
+		// macro use like @bass, @bass-2 or @bass+1*4
+		name, n := macroName(line[1:])
+		use := line[:1+n]
+		runes := []rune(line[1+n:])
+		offset := 0
+		if len(runes) > 0 && (runes[0] == '+' || runes[0] == '-') {
+			cents, m := parseCents(runes)
+			offset = int(cents)
+			runes = runes[m:]
+		}
+		count := ""
+		if len(runes) > 0 && runes[0] == '*' {
+			_, m := parseNumber(runes[1:])
+			count = string(runes[:m+1])
+			runes = runes[m+1:]
+		}
+		column += utf8.RuneCountInString(line) - len(runes)
+		line = string(runes)
+		if offset += semitones; offset != 0 {
+			use += fmt.Sprintf("%+d", offset)
+		}
+		buf.WriteString(use + count)
+		if expanded, err := expandMacros("@"+name, macros, *hand, nil); err == nil {
+			*hand = notationHand(expanded, *hand)
+		}
+	}
+	if len(outOfRange.notes) > 0 {
+		return "", outOfRange
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,53 @@
+package beep
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+func ExampleTransposeSheet() {
+	sheet := `# melody
+@bass = HLDE z,HReq
+DQ qwer  |  tyui VN
+HL @bass @bass-1*2 <Am/G>
+{fine} HRl. ,`
+	transposed, err := TransposeSheet(sheet, 3)
+	fmt.Println(transposed)
+	fmt.Println(err)
+
+	_, err = TransposeSheet("H7i", 1)
+	fmt.Println(err)
+	_, err = TransposeSheet("H7qi {segno} @top u", 12)
+	fmt.Println(err)
+
+	// Output:
+	// # melody
+	// @bass = HLDE z,HReq
+	// DQ 3rt6  |  7io0 VN
+	// HL @bass+3 @bass+2*2 <Cm/Bb:+1>
+	// {fine} H72w qHR
+	// <nil>
+	// line 1: transposed out of range: note i at column 3
+	// line 1: transposed out of range: note i at column 4, note u at column 19
+}
+
+func ExampleScore_Transpose() {
+	sheet := "HRq2w3 VN\nHLmk,l.\nHRm"
+	score, err := ParseScore(bufio.NewReader(strings.NewReader(sheet)), "")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := score.Transpose(-2); err != nil {
+		fmt.Println(err)
+	}
+	for _, line := range score.Lines {
+		fmt.Println(line.Text)
+	}
+
+	// Output:
+	// HLl.HRq2VN
+	// HLnjmk,
+	// HRn
+}
@@ -0,0 +1,288 @@
+package beep
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tuning - maps MIDI note numbers to frequencies
+type Tuning struct {
+	Name         string
+	cents        []float64 // scale degrees from root, the last degree is the period
+	root         int       // MIDI note of scale degree 0
+	refNote      int       // MIDI note of reference frequency
+	refFreq      float64   // reference frequency in hertz
+	mapping      []int     // keyboard mapping of .kbm file, -1 is unmapped key
+	octaveDegree int       // scale degree of mapping period
+}
+
+// Cents of 12 note temperaments from C
+var temperaments = map[string][]float64{
+	"equal": {0, 100, 200, 300, 400, 500, 600, 700, 800, 900, 1000, 1100},
+	"just": ratiosToCents(1, 16.0/15, 9.0/8, 6.0/5, 5.0/4, 4.0/3, 45.0/32,
+		3.0/2, 8.0/5, 5.0/3, 9.0/5, 15.0/8),
+	"pythagorean": ratiosToCents(1, 256.0/243, 9.0/8, 32.0/27, 81.0/64, 4.0/3, 729.0/512,
+		3.0/2, 128.0/81, 27.0/16, 16.0/9, 243.0/128),
+	"meantone": {0, 76.049, 193.157, 310.265, 386.314, 503.422, 579.471,
+		696.578, 772.627, 889.735, 1006.843, 1082.892}, // quarter-comma
+	"werckmeister": {0, 90.225, 192.18, 294.135, 390.225, 498.045, 588.27,
+		696.09, 792.18, 888.27, 996.09, 1092.18}, // Werckmeister III
+	"vallotti": {0, 94.135, 196.09, 298.045, 392.18, 501.955, 592.18,
+		698.045, 796.09, 894.135, 1000, 1090.225},
+}
+
+// Returns cents of frequency ratios
+func ratiosToCents(ratios ...float64) []float64 {
+	cents := make([]float64, len(ratios))
+	for i, ratio := range ratios {
+		cents[i] = 1200 * math.Log2(ratio)
+	}
+	return cents
+}
+
+// TuningNames returns names of built-in temperaments
+func TuningNames() []string {
+	var names []string
+	for name := range temperaments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTuning returns a built-in temperament with C as root and
+// A4 tuned to reference frequency in hertz
+func NewTuning(name string, reference float64) (*Tuning, error) {
+	table, found := temperaments[name]
+	if !found {
+		return nil, fmt.Errorf("unknown tuning %s, must be one of: %s or a .scl file",
+			name, strings.Join(TuningNames(), ", "))
+	}
+	if reference <= 0 {
+		reference = 440
+	}
+	cents := append([]float64{}, table[1:]...)
+	return &Tuning{
+		Name:    name,
+		cents:   append(cents, 1200),
+		root:    60,
+		refNote: 69,
+		refFreq: reference,
+	}, nil
+}
+
+// SetReference tunes A4 to frequency in hertz
+func (t *Tuning) SetReference(freq float64) {
+	t.refNote = 69
+	t.refFreq = freq
+}
+
+// Frequency returns frequency of MIDI note number, 0 if the key is unmapped
+func (t *Tuning) Frequency(number int) float64 {
+	cents, ok := t.noteCents(number)
+	refCents, refOk := t.noteCents(t.refNote)
+	if !ok || !refOk {
+		return 0
+	}
+	return t.refFreq * math.Pow(2, (cents-refCents)/1200)
+}
+
+// Returns cents of MIDI note number from root
+func (t *Tuning) noteCents(number int) (float64, bool) {
+	offset := number - t.root
+	if len(t.mapping) == 0 {
+		return t.degreeCents(offset), true
+	}
+	size := len(t.mapping)
+	octave := floorDiv(offset, size)
+	degree := t.mapping[offset-octave*size]
+	if degree < 0 {
+		return 0, false
+	}
+	return float64(octave)*t.degreeCents(t.octaveDegree) + t.degreeCents(degree), true
+}
+
+// Returns cents of scale degree, degrees beyond the scale repeat by period
+func (t *Tuning) degreeCents(degree int) float64 {
+	size := len(t.cents)
+	period := t.cents[size-1]
+	octave := floorDiv(degree, size)
+	degree -= octave * size
+	cents := float64(octave) * period
+	if degree > 0 {
+		cents += t.cents[degree-1]
+	}
+	return cents
+}
+
+// Returns frequency ratio of tuned note to equal tempered A4 440 hertz note
+func (t *Tuning) detune(number int) float64 {
+	freq := t.Frequency(number)
+	if freq == 0 {
+		return 1
+	}
+	return freq / (440 * math.Pow(2, float64(number-69)/12))
+}
+
+// Returns frequencies of keys tuned to tuning, nil is equal temperament
+// with A4 at 440 hertz. Keys unmapped by the tuning keep equal temperament.
+func tuneKeys(keyNoteMap map[rune]string, tuning *Tuning) map[rune]float64 {
+	keyFreqMap := make(map[rune]float64)
+	for key := range keyNoteMap {
+		number, found := keyMidiNoteMap[key]
+		if !found {
+			continue
+		}
+		keyFreqMap[key] = 440 * math.Pow(2, float64(int(number)-69)/12)
+		if tuning != nil {
+			keyFreqMap[key] *= tuning.detune(int(number))
+		}
+	}
+	return keyFreqMap
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// LoadScala loads a Scala scale file and optional keyboard mapping file.
+// Without a mapping, scale degree 0 is middle C and A4 is 440 hertz.
+func LoadScala(sclFile, kbmFile string) (*Tuning, error) {
+	lines, err := scalaLines(sclFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) < 2 {
+		return nil, errors.New("invalid Scala file")
+	}
+	count, err := strconv.Atoi(strings.Fields(lines[1] + " x")[0])
+	if err != nil || count < 1 || len(lines) < count+2 {
+		return nil, errors.New("invalid Scala note count")
+	}
+	t := &Tuning{
+		Name:    strings.TrimSuffix(filepath.Base(sclFile), filepath.Ext(sclFile)),
+		root:    60,
+		refNote: 69,
+		refFreq: 440,
+	}
+	for _, line := range lines[2 : count+2] {
+		cents, err := scalaPitch(line)
+		if err != nil {
+			return nil, err
+		}
+		t.cents = append(t.cents, cents)
+	}
+	if len(kbmFile) > 0 {
+		if err := t.loadKeyboardMapping(kbmFile); err != nil {
+			return nil, err
+		}
+	} else if len(t.cents) != 12 {
+		// keep A4 at 440 hertz for 12 note scales only, others are tuned from middle C
+		t.refNote = 60
+		t.refFreq = 440 * math.Pow(2, -9.0/12)
+	}
+	return t, nil
+}
+
+// Reads a Scala keyboard mapping file
+func (t *Tuning) loadKeyboardMapping(filename string) error {
+	lines, err := scalaLines(filename)
+	if err != nil {
+		return err
+	}
+	if len(lines) < 7 {
+		return errors.New("invalid keyboard mapping file")
+	}
+	var values [7]float64
+	for i := range values {
+		field := strings.Fields(lines[i] + " x")[0]
+		if values[i], err = strconv.ParseFloat(field, 64); err != nil {
+			return fmt.Errorf("invalid keyboard mapping value: %s", lines[i])
+		}
+	}
+	size := int(values[0])
+	t.root = int(values[3])
+	t.refNote = int(values[4])
+	t.refFreq = values[5]
+	t.octaveDegree = int(values[6])
+	if size == 0 {
+		// linear mapping
+		t.mapping = nil
+		return nil
+	}
+	if len(lines) < 7+size {
+		return errors.New("keyboard mapping has too few keys")
+	}
+	if t.octaveDegree < 0 {
+		return fmt.Errorf("invalid keyboard mapping octave degree: %d", t.octaveDegree)
+	}
+	if t.octaveDegree == 0 {
+		// mapping repeats at period of the scale
+		t.octaveDegree = len(t.cents)
+	}
+	t.mapping = make([]int, size)
+	for i, line := range lines[7 : 7+size] {
+		field := strings.Fields(line + " x")[0]
+		if field == "x" {
+			t.mapping[i] = -1
+			continue
+		}
+		if t.mapping[i], err = strconv.Atoi(field); err != nil {
+			return fmt.Errorf("invalid keyboard mapping key: %s", line)
+		}
+	}
+	return nil
+}
+
+// Returns lines of a Scala file without comments
+func scalaLines(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// Parses Scala pitch in cents like 701.955 or ratio like 3/2, returns cents
+func scalaPitch(line string) (float64, error) {
+	field := strings.Fields(line + " x")[0]
+	if strings.Contains(field, ".") {
+		return strconv.ParseFloat(field, 64)
+	}
+	ratio := strings.SplitN(field, "/", 2)
+	num, err := strconv.ParseFloat(ratio[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Scala pitch: %s", line)
+	}
+	den := 1.0
+	if len(ratio) == 2 {
+		if den, err = strconv.ParseFloat(ratio[1], 64); err != nil {
+			return 0, fmt.Errorf("invalid Scala pitch: %s", line)
+		}
+	}
+	if num <= 0 || den <= 0 {
+		return 0, fmt.Errorf("invalid Scala pitch: %s", line)
+	}
+	return 1200 * math.Log2(num/den), nil
+}
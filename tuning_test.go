@@ -0,0 +1,66 @@
+package beep
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func ExampleNewTuning() {
+	for _, name := range []string{"equal", "just", "meantone"} {
+		tuning, _ := NewTuning(name, 432)
+		// C4, E4, G4, A4
+		fmt.Printf("%-8s %.2f %.2f %.2f %.2f\n", name, tuning.Frequency(60),
+			tuning.Frequency(64), tuning.Frequency(67), tuning.Frequency(69))
+	}
+
+	// Output:
+	// equal    256.87 323.63 384.87 432.00
+	// just     259.20 324.00 388.80 432.00
+	// meantone 258.40 323.00 386.39 432.00
+}
+
+func ExampleLoadScala() {
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+
+	// 5 note scale with 3/2 period, degree 0 at D4 tuned to 300 Hz
+	scl := filepath.Join(dir, "test.scl")
+	ioutil.WriteFile(scl, []byte("! test.scl\nTest scale\n 3\n!\n100.0\n5/4\n3/2\n"), 0644)
+	kbm := filepath.Join(dir, "test.kbm")
+	ioutil.WriteFile(kbm, []byte("! test.kbm\n4\n0\n127\n62\n62\n300.0\n3\n0\n1\nx\n2\n"), 0644)
+
+	tuning, err := LoadScala(scl, kbm)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for number := 61; number <= 67; number++ {
+		fmt.Printf("%d %.2f\n", number, tuning.Frequency(number))
+	}
+
+	// octave degree 0 repeats the mapping at the 3/2 period
+	ioutil.WriteFile(kbm, []byte("! test.kbm\n2\n0\n127\n62\n62\n300.0\n0\n0\n2\n"), 0644)
+	tuning, _ = LoadScala(scl, kbm)
+	for number := 62; number <= 65; number++ {
+		fmt.Printf("%d %.2f\n", number, tuning.Frequency(number))
+	}
+	ioutil.WriteFile(kbm, []byte("! test.kbm\n2\n0\n127\n62\n62\n300.0\n-1\n0\n2\n"), 0644)
+	_, err = LoadScala(scl, kbm)
+	fmt.Println(err)
+
+	// Output:
+	// 61 250.00
+	// 62 300.00
+	// 63 317.84
+	// 64 0.00
+	// 65 375.00
+	// 66 450.00
+	// 67 476.76
+	// 62 300.00
+	// 63 375.00
+	// 64 450.00
+	// 65 562.50
+	// invalid keyboard mapping octave degree: -1
+}
@@ -1,6 +1,8 @@
 package beep
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -216,3 +218,284 @@ func stringNumber(s string) int {
 	}
 	return n
 }
+
+// Parses unsigned number at start of runes, returns number and
+// number of runes read, 0 if none
+func parseNumber(runes []rune) (int, int) {
+	n := 0
+	for n < len(runes) && runes[n] >= '0' && runes[n] <= '9' {
+		n++
+	}
+	number, err := strconv.Atoi(string(runes[:n]))
+	if err != nil {
+		return 0, 0
+	}
+	return number, n
+}
+
+// Parses whole cents like +50, -25 or 30 at start of runes,
+// returns cents and number of runes read, 0 if none
+func parseCents(runes []rune) (float64, int) {
+	sign := 0
+	if len(runes) > 0 && (runes[0] == '+' || runes[0] == '-') {
+		sign = 1
+	}
+	cents, n := parseNumber(runes[sign:])
+	if n == 0 {
+		return 0, 0
+	}
+	if sign > 0 && runes[0] == '-' {
+		cents = -cents
+	}
+	return float64(cents), n + sign
+}
+
+// Parses tuplet like 3:2 or 3 at start of runes, returns number of notes,
+// number of normal notes they take time of and number of runes read, 0 if none.
+// Without a span, notes take time of the next lower power of two, 2 of 3.
+func parseTuplet(runes []rune) (int, int, int) {
+	notes, n := parseNumber(runes)
+	if n == 0 || notes < 2 {
+		return 0, 0, 0
+	}
+	if n < len(runes) && runes[n] == ':' {
+		span, m := parseNumber(runes[n+1:])
+		if m == 0 || span < 1 {
+			return 0, 0, 0
+		}
+		return notes, span, n + m + 1
+	}
+	if notes == 2 {
+		return 2, 3, n
+	}
+	span := 1
+	for span*2 < notes {
+		span *= 2
+	}
+	return notes, span, n
+}
+
+// WaveData - decoded WAV samples
+type WaveData struct {
+	Samples       []int16 // mono 16-bit samples
+	SampleRate    int
+	Channels      int // channels of the file before mixing to mono
+	BitsPerSample int // sample size of the file
+	RootKey       int // MIDI unity note from 'smpl' chunk, 0 if not found
+	LoopStart     int // loop start from 'smpl' chunk
+	LoopEnd       int // loop end from 'smpl' chunk, 0 if not found
+}
+
+// DecodeWave decodes a RIFF WAV file into mono 16-bit samples.
+// 8, 16, 24 and 32-bit integer PCM and 32/64-bit float samples are supported.
+func DecodeWave(data []byte) (*WaveData, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+	var (
+		format   int
+		channels int
+		bits     int
+		pcm      []byte
+		wave     = &WaveData{}
+	)
+	for pos := 12; pos+8 <= len(data); {
+		chunkID := string(data[pos : pos+4])
+		size := int(uint32(bytesToInt32(data[pos+4 : pos+8])))
+		start := pos + 8
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[start:end]
+		switch chunkID {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, errors.New("invalid WAV format chunk")
+			}
+			format = int(uint16(bytesToInt16(chunk[0:2])))
+			channels = int(bytesToInt16(chunk[2:4]))
+			wave.SampleRate = int(bytesToInt32(chunk[4:8]))
+			bits = int(bytesToInt16(chunk[14:16]))
+			if format == 0xFFFE && len(chunk) >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE, sub format GUID starts with format code
+				format = int(bytesToInt16(chunk[24:26]))
+			}
+		case "data":
+			pcm = chunk
+		case "smpl":
+			if len(chunk) >= 36 {
+				wave.RootKey = int(bytesToInt32(chunk[12:16]))
+				loops := int(bytesToInt32(chunk[28:32]))
+				if loops > 0 && len(chunk) >= 60 {
+					wave.LoopStart = int(bytesToInt32(chunk[44:48]))
+					wave.LoopEnd = int(bytesToInt32(chunk[48:52]))
+				}
+			}
+		}
+		pos = start + size + size%2 // chunks are word aligned
+	}
+	if channels < 1 || bits == 0 || pcm == nil {
+		return nil, errors.New("WAV file has no audio data")
+	}
+	if format != 1 && format != 3 {
+		return nil, fmt.Errorf("unsupported WAV audio format: %d", format)
+	}
+	width := bits / 8
+	frame := width * channels
+	if width == 0 || (format == 3 && width != 4 && width != 8) {
+		return nil, fmt.Errorf("unsupported WAV sample size: %d bits", bits)
+	}
+	wave.Channels = channels
+	wave.BitsPerSample = bits
+	wave.Samples = make([]int16, len(pcm)/frame)
+	for i := range wave.Samples {
+		var sum float64
+		for c := 0; c < channels; c++ {
+			sum += waveSample(pcm[i*frame+c*width:], width, format == 3)
+		}
+		bar := sum / float64(channels) * SampleAmp16bit
+		if bar > SampleAmp16bit {
+			bar = SampleAmp16bit
+		} else if bar < -SampleAmp16bit {
+			bar = -SampleAmp16bit
+		}
+		wave.Samples[i] = int16(bar)
+	}
+	return wave, nil
+}
+
+// Returns a sample as -1.0 to 1.0
+func waveSample(buf []byte, width int, float bool) float64 {
+	if float {
+		if width == 8 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(buf))
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(buf)))
+	}
+	switch width {
+	case 1:
+		return (float64(buf[0]) - 128) / 128 // 8-bit samples are unsigned
+	case 2:
+		return float64(bytesToInt16(buf)) / 32768
+	case 3:
+		return float64(int32(uint32(buf[0])<<8|uint32(buf[1])<<16|uint32(buf[2])<<24)) / 2147483648
+	case 4:
+		return float64(bytesToInt32(buf)) / 2147483648
+	}
+	return 0
+}
+
+// Returns index of next key after index k that is not ignored,
+// length of keys if none
+func nextKey(keys []rune, k int, ignored string) int {
+	for k++; k < len(keys); k++ {
+		if !strings.ContainsRune(ignored, keys[k]) {
+			break
+		}
+	}
+	return k
+}
+
+// Parses tempo change like =120, >160 or <90:8 at start of runes, returns
+// change sign, beats per minute, beats of gradual change, 0 if not given,
+// and number of runes read, 0 if invalid
+func parseTempo(runes []rune) (rune, int, int, int) {
+	if len(runes) == 0 || !strings.ContainsRune("=<>", runes[0]) {
+		return 0, 0, 0, 0
+	}
+	bpm, n := parseNumber(runes[1:])
+	if n == 0 || bpm < 1 {
+		return 0, 0, 0, 0
+	}
+	n++
+	if runes[0] != '=' && n < len(runes) && runes[n] == ':' {
+		beats, m := parseNumber(runes[n+1:])
+		if m == 0 || beats < 1 {
+			return 0, 0, 0, 0
+		}
+		return runes[0], bpm, beats, n + m + 1
+	}
+	return runes[0], bpm, 0, n
+}
+
+// Parses time signature like 3/4 at start of runes, returns beats,
+// note value of beat and number of runes read, 0 if invalid
+func parseTimeSignature(runes []rune) (int, int, int) {
+	beats, n := parseNumber(runes)
+	if n == 0 || beats < 1 || n >= len(runes) || runes[n] != '/' {
+		return 0, 0, 0
+	}
+	unit, m := parseNumber(runes[n+1:])
+	if m == 0 || unit < 1 || unit > 64 || unit&(unit-1) != 0 {
+		return 0, 0, 0
+	}
+	return beats, unit, n + m + 1
+}
+
+// Amplitude levels of dynamic markings
+var dynamicLevels = map[string]int{
+	"pp": 2, "p": 3, "mp": 5, "mf": 6, "f": 8, "ff": 9,
+}
+
+// Parses dynamics like pp, <, or >p:8 at start of runes, returns hairpin
+// sign, 0 if none, amplitude level of marking, 0 if none, beats of
+// hairpin, 0 if not given, and number of runes read, 0 if invalid
+func parseDynamics(runes []rune) (rune, int, int, int) {
+	var hairpin rune
+	n := 0
+	if len(runes) > 0 && (runes[0] == '<' || runes[0] == '>') {
+		hairpin = runes[0]
+		n++
+	}
+	level := 0
+	for size := 2; size > 0 && level == 0; size-- {
+		if n+size <= len(runes) {
+			if l, found := dynamicLevels[string(runes[n:n+size])]; found {
+				level = l
+				n += size
+			}
+		}
+	}
+	switch {
+	case hairpin == 0 && level == 0:
+		return 0, 0, 0, 0
+	case hairpin == 0 || level == 0:
+		return hairpin, level, 0, n
+	}
+	// hairpin with target level takes beats like <f:4
+	if n >= len(runes) || runes[n] != ':' {
+		return 0, 0, 0, 0
+	}
+	beats, m := parseNumber(runes[n+1:])
+	if m == 0 || beats < 1 {
+		return 0, 0, 0, 0
+	}
+	return hairpin, level, beats, n + m + 1
+}
+
+// Parses arpeggiator like UE, BS2 or RE1:50 at start of runes, returns
+// mode, 0 if off, note duration of steps, octave range, gate percent
+// and number of runes read, 0 if invalid
+func parseArpeggio(runes []rune) (rune, rune, int, int, int) {
+	if len(runes) > 0 && runes[0] == '0' {
+		return 0, 0, 0, 0, 1
+	}
+	if len(runes) < 2 || !strings.ContainsRune("UDBR", runes[0]) || !strings.ContainsRune("WHQESTI", runes[1]) {
+		return 0, 0, 0, 0, 0
+	}
+	mode, rate, octaves, gate, n := runes[0], runes[1], 1, 80, 2
+	if n < len(runes) && runes[n] >= '1' && runes[n] <= '4' {
+		octaves = int(runes[n] - '0')
+		n++
+	}
+	if n < len(runes) && runes[n] == ':' {
+		percent, m := parseNumber(runes[n+1:])
+		if m == 0 || percent < 1 || percent > 100 {
+			return 0, 0, 0, 0, 0
+		}
+		gate = percent
+		n += m + 1
+	}
+	return mode, rate, octaves, gate, n
+}
@@ -1,13 +1,11 @@
 package beep
 
 import (
-	"archive/zip"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 )
 
 // Violin voice
@@ -15,17 +13,17 @@ type Violin struct {
 	naturalVoice      bool
 	naturalVoiceFound bool
 	keyDefMap         map[rune][]int16 // default voice
-	keyNatMap         map[rune][]int16 // natural voice
+	natural           *voicePack       // natural voice
 	keyFreqMap        map[rune]float64
 	keyNoteMap        map[rune]string
 	noteKeyMap        map[string]rune
+	mutex             sync.Mutex
 }
 
 // NewViolin return new violin voice
 func NewViolin() *Violin {
 	v := &Violin{
 		keyDefMap:  make(map[rune][]int16),
-		keyNatMap:  make(map[rune][]int16),
 		keyFreqMap: make(map[rune]float64),
 		keyNoteMap: make(map[rune]string),
 		noteKeyMap: make(map[string]rune),
@@ -33,21 +31,6 @@ func NewViolin() *Violin {
 
 	keys := "q2w3er5t6y7ui9o0p[=]azsxcfvgbnjmk,l."
 
-	octaveFreq3 := []float64{
-		// C3, Db3, D3, Eb3, E3
-		196.0, 207.6, 220.0, 233.0, 246.9, // 3
-	}
-	octaveFreq456 := []float64{
-		// C4, Db4, D4, Eb4, E4, F4, Gb4, G4, Ab4, A4, Bb4, B4
-		261.6, 277.1, 293.6, 311.1, 329.6, 349.2, 369.9, 392.0, 415.3, 440.0, 466.1, 493.8, // 4
-		523.2, 554.3, 587.3, 622.2, 659.2, 698.4, 739.9, 783.9, 830.6, 880.0, 932.3, 987.7, // 5
-		1046.5, 1108.7, 1174.6, 1244.5, 1318.5, 1396.9, 1479.9, 1567, 1661, 1760, 1864, 1975, // 6
-	}
-	octaveFreq7 := []float64{
-		// C7, Db7, D7, Eb7, E7
-		2093, 2217.5, 2349.3, 2489, 2637, 2793, 2960,
-	}
-
 	noteNames := []string{
 		"G3", "Ab3", "A3", "Bb3", "B3",
 		"C4", "Db4", "D4", "Eb4", "E4", "F4", "Gb4", "G4", "Ab4", "A4", "Bb4", "B4",
@@ -58,87 +41,74 @@ func NewViolin() *Violin {
 
 	// initialize maps
 	ni := 0
-	for i, key := range keys[31:] { // actave 3
+	for _, key := range keys[31:] { // actave 3
 		keyID := 2000 + key
 		note := noteNames[ni]
-		v.keyFreqMap[keyID] = octaveFreq3[i]
 		v.keyNoteMap[keyID] = note
 		v.noteKeyMap[note] = keyID
 		ni++
 	}
-	for i, key := range keys { // actave 4, 5, 6
+	for _, key := range keys { // actave 4, 5, 6
 		keyID := 3000 + key
 		note := noteNames[ni]
-		v.keyFreqMap[keyID] = octaveFreq456[i]
 		v.keyNoteMap[keyID] = note
 		v.noteKeyMap[note] = keyID
 		ni++
 	}
-	for i, key := range keys[:5] { // actave 7
+	for _, key := range keys[:5] { // actave 7
 		keyID := 4000 + key
 		note := noteNames[ni]
-		v.keyFreqMap[keyID] = octaveFreq7[i]
 		v.keyNoteMap[keyID] = note
 		v.noteKeyMap[note] = keyID
 		ni++
 	}
-	for key := range v.keyFreqMap {
-		// generate default violin voice
-		v.keyDefMap[key] = v.generateNote(key, wholeNote)
-	}
+	v.setTuning(nil)
 
 	// load natural voice file, if exists
 	filename := filepath.Join(HomeDir(), "voices", "violin.zip")
-	voiceFile, err := zip.OpenReader(filename)
+	natural, err := loadVoicePack(filename, v.noteKeyMap)
 	if err == nil {
 		// voice file exists
-		defer voiceFile.Close()
+		v.natural = natural
 		v.naturalVoice = true
-		for _, zfile := range voiceFile.File {
-			file, err := zfile.Open()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Unable to open file from zip:", zfile.Name)
-				continue
-			}
-			defer file.Close()
-			if !strings.HasSuffix(zfile.Name, ".wav") {
-				continue
-			}
-			noteName := strings.Split(filepath.Base(zfile.Name), ".")[0]
-			if key, found := v.noteKeyMap[noteName]; found {
-				var header WaveHeader
-				header.ReadHeader(file)
-				if header.SampleRate != 44100 || header.BitsPerSample != 16 {
-					fmt.Fprintln(os.Stderr, "Unsupported sample file:", zfile.Name)
-					continue
-				}
-				buf, err := ioutil.ReadAll(file)
-				if err != nil {
-					fmt.Fprintln(os.Stderr, "Unable to read file from zip:", zfile.Name)
-					continue
-				}
-				rest := wholeNote - len(buf)/2
-				if rest > 0 {
-					// too short, sample should be a whole note
-					bufRest := make([]byte, rest*2)
-					buf = append(buf, bufRest...)
-				}
-				buf16 := byteToInt16Buf(buf)
-				if len(buf16) < wholeNote {
-					fmt.Fprintln(os.Stderr, "Sample note duration must be 90112 samples long.")
-				}
-				trimWave(buf16)
-				v.keyNatMap[key] = buf16
-			} else {
-				fmt.Fprintln(os.Stderr, "Unknown note name in voice file:", noteName)
-			}
-		}
+		v.naturalVoiceFound = natural.found()
+		// pitch-shift recorded notes to missing keys
+		natural.fill(v.keyNoteMap)
+	} else if !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Invalid voice file %s: %v\n", filename, err)
 	}
 
 	return v
 }
 
-func (v *Violin) generateNote(key rune, duration int) []int16 {
+// Tunes keys to tuning, nil is equal temperament with A4 at 440 hertz
+func (v *Violin) setTuning(tuning *Tuning) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.keyFreqMap = tuneKeys(v.keyNoteMap, tuning)
+	v.keyDefMap = make(map[rune][]int16)
+	if v.natural != nil {
+		v.natural.retune(tuning)
+	}
+}
+
+// Returns default voice of key, generated on first use
+func (v *Violin) defaultNote(key rune) ([]int16, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if buf, found := v.keyDefMap[key]; found {
+		return buf, true
+	}
+	if _, found := v.keyFreqMap[key]; !found {
+		return nil, false
+	}
+	buf := v.generateNote(key, nil, wholeNote)
+	v.keyDefMap[key] = buf
+	return buf, true
+}
+
+// Generates default voice of key, at the pitch of note if not nil
+func (v *Violin) generateNote(key rune, note *Note, duration int) []int16 {
 	// default voice
 	freq, found := v.keyFreqMap[key]
 	if !found {
@@ -155,7 +125,11 @@ func (v *Violin) generateNote(key rune, duration int) []int16 {
 	tick2 := tick1 * 3
 	tick3 := tick2 * 4
 	amp := SampleAmp16bit * 0.5
+	ratio := 1.0
 	for i := range buf {
+		if note != nil {
+			ratio = math.Pow(2, note.pitchCents(i)/1200)
+		}
 		sin0 := math.Sin(timer0)
 		sin1 := sin0 * math.Sin(timer1)
 		sin2 := sin1 * math.Sin(timer2)
@@ -165,10 +139,10 @@ func (v *Violin) generateNote(key rune, duration int) []int16 {
 		bar2 := bar0 * sin2 / 3 * sin0
 		bar3 := bar0 * sin3 / 4 * sin0
 		buf[i] = int16(bar0 + bar1 + bar2 + bar3)
-		timer0 += tick0
-		timer1 += tick1
-		timer2 += tick2
-		timer3 += tick3
+		timer0 += tick0 * ratio
+		timer1 += tick1 * ratio
+		timer2 += tick2 * ratio
+		timer3 += tick3 * ratio
 	}
 	trimWave(buf)
 	return buf
@@ -176,20 +150,40 @@ func (v *Violin) generateNote(key rune, duration int) []int16 {
 
 // GetNote prepares note wave form
 func (v *Violin) GetNote(note *Note, sustain *Sustain) (found bool) {
-	var bufNote []int16
+	var buf []int16
+	volume, amplitude := note.volume, note.amplitude
 	if v.naturalVoice {
-		bufNote, found = v.keyNatMap[note.key]
-	}
-	if !found {
-		bufNote, found = v.keyDefMap[note.key]
+		var gain float64
+		buf, gain, found = v.natural.sample(note)
+		if found {
+			// velocity layer sets amplitude
+			volume, amplitude = int(float64(volume)*gain), 0
+			if note.bent() {
+				buf = bendNote(buf, note)
+			}
+		}
 	}
 	if !found {
-		return
+		var bufNote []int16
+		bufNote, found = v.defaultNote(note.key)
+		if !found {
+			return
+		}
+		if note.bent() || note.samples > len(bufNote) {
+			// synthesize the exact pitch, or a note longer than whole note
+			duration := wholeNote
+			if note.samples > duration {
+				duration = note.samples
+			}
+			v.mutex.Lock()
+			buf = v.generateNote(note.key, note, duration)
+			v.mutex.Unlock()
+		} else {
+			buf = make([]int16, len(bufNote))
+			copy(buf, bufNote) // get a copy of the note
+		}
 	}
-
-	buf := make([]int16, len(bufNote))
-	copy(buf, bufNote) // get a copy of the note
-	applyNoteVolume(buf, note.volume, note.amplitude)
+	applyNoteVolume(buf, volume, amplitude)
 
 	// Sustain note
 	if note.duration == 'W' {
@@ -198,11 +192,14 @@ func (v *Violin) GetNote(note *Note, sustain *Sustain) (found bool) {
 			// sustain current note
 			copyBuffer(sustain.buf, buf[len(buf)/3:])
 		}
-	} else {
-		if v.NaturalVoice() {
-			// sustain current note
-			copyBuffer(sustain.buf, buf[note.samples:])
+	} else if v.NaturalVoice() {
+		// tied notes may be longer than sample
+		end := note.samples
+		if end > len(buf) {
+			end = len(buf)
 		}
+		// sustain current note
+		copyBuffer(sustain.buf, buf[end:])
 	}
 
 	// measure note
@@ -217,7 +214,9 @@ func (v *Violin) GetNote(note *Note, sustain *Sustain) (found bool) {
 	trimWave(buf)
 
 	// release note
-	releaseNote(buf, 0, 0.99)
+	if !note.held() {
+		releaseNote(buf, 0, 0.99)
+	}
 
 	note.buf = buf
 
@@ -280,8 +279,14 @@ func (v *Violin) SustainNote(note *Note, sustain *Sustain) {
 	volume64 := float64(note.volume)
 	if v.naturalVoice {
 		attack := float64(9-sustain.attack) / 10
-		v.raiseNote(note, attack)
 		release := float64(1+sustain.release) / 10
+		if note.slurIn {
+			attack = 0.01
+		}
+		if note.held() {
+			release = 0.99
+		}
+		v.raiseNote(note, attack)
 		releaseNote(buf, 0, release)
 		return
 	}
@@ -298,8 +303,16 @@ func (v *Violin) SustainNote(note *Note, sustain *Sustain) {
 	}
 	attack := int(float64(buflen/200) * float64(sustain.attack))
 	decay := (buflen-attack)/10 + ((buflen - attack) / 20 * sustain.decay)
+	if note.slurIn {
+		// legato, start on sustain level
+		attack, decay = 0, 0
+	}
 	S := int16(volume64 / 10.0 * float64(sustain.sustain+1))
 	sustainCount := (buflen - attack - decay) / 2
+	if note.held() {
+		// legato, hold sustain level until next note
+		sustainCount = buflen - attack - decay
+	}
 	R := buflen - attack - decay - sustainCount
 	attack64 := float64(attack)
 	decay64 := float64(decay)
@@ -0,0 +1,312 @@
+package beep
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Note names of voice file samples by semitone
+var voiceNoteNames = []string{"C", "Db", "D", "Eb", "E", "F", "Gb", "G", "Ab", "A", "Bb", "B"}
+
+// Finds a note name like C4, F#3 or Bb5 in a file name
+var noteNamePattern = regexp.MustCompile(`(?:^|[^A-Za-z])([A-Ga-g][#b]?[0-8])(?:[^0-9]|$)`)
+
+const (
+	buildSilence = 64.0                   // amplitude below which samples are silence
+	buildLevel   = SampleAmp16bit * 0.125 // RMS level of normalized notes, -18 dBFS
+	buildPeak    = SampleAmp16bit * 0.89  // peak limit of normalized notes, -1 dBFS
+	buildFade    = 4096                   // fade out of notes longer than a whole note
+)
+
+// A recording converted to a voice file sample
+type buildSample struct {
+	source string
+	number int // MIDI note number
+	level  int // velocity layer
+	buf    []int16
+	pitch  float64 // detected frequency, 0 if note is from file name
+}
+
+// BuildVoice builds a natural voice file from a directory of WAV recordings.
+// Notes are read from file names like C4.wav or organ-C4_v2.wav, or detected
+// from the pitch of the recording. Samples are trimmed, normalized and
+// converted to 44.1 kHz 16-bit whole notes.
+func BuildVoice(dir, output string, writer io.Writer) error {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.[wW][aA][vV]"))
+	if err != nil {
+		return err
+	}
+	if len(filenames) == 0 {
+		return fmt.Errorf("no WAV files found in %s", dir)
+	}
+	sort.Strings(filenames)
+
+	var samples []*buildSample
+	for _, filename := range filenames {
+		sample, err := newBuildSample(filename)
+		if err != nil {
+			fmt.Fprintf(writer, "Skipping %s: %v\n", filepath.Base(filename), err)
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if len(samples) == 0 {
+		return errors.New("no usable recordings")
+	}
+	normalizeSamples(samples)
+
+	// name samples, notes recorded more than once become round-robin alternates
+	groups := make(map[string][]*buildSample)
+	var names []string
+	for _, sample := range samples {
+		name := voiceNoteName(sample.number)
+		if sample.level > 1 {
+			name += fmt.Sprintf("_v%d", sample.level)
+		}
+		if groups[name] == nil {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], sample)
+	}
+
+	var zipBuf bytes.Buffer
+	zipFile := zip.NewWriter(&zipBuf)
+	voiceName := strings.TrimSuffix(filepath.Base(output), filepath.Ext(output))
+	manifest := &VoiceManifest{
+		Name:    voiceName,
+		Version: "1.0",
+		Tuning:  440,
+		Format: VoiceFormat{
+			SampleRate:    SampleRate,
+			BitsPerSample: 16,
+			Channels:      1,
+		},
+		Files: make(map[string]string),
+	}
+	for _, name := range names {
+		group := groups[name]
+		for i, sample := range group {
+			filename := name + ".wav"
+			if len(group) > 1 {
+				filename = fmt.Sprintf("%s_r%d.wav", name, i+1)
+			}
+			var wav bytes.Buffer
+			NewWaveHeader(1, SampleRate, 16, len(sample.buf)*2).WriteHeader(&wav)
+			wav.Write(int16ToByteBuf(sample.buf))
+			f, err := zipFile.Create(filename)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(wav.Bytes()); err != nil {
+				return err
+			}
+			hash := sha256.Sum256(wav.Bytes())
+			manifest.Files[filename] = hex.EncodeToString(hash[:])
+			if sample.pitch > 0 {
+				fmt.Fprintf(writer, "%-12s <- %s (%.1f Hz)\n", filename, sample.source, sample.pitch)
+			} else {
+				fmt.Fprintf(writer, "%-12s <- %s\n", filename, sample.source)
+			}
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := zipFile.Create(voiceManifestName)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := zipFile.Close(); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(output, zipBuf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "wrote %d samples to '%s'\n", len(manifest.Files), output)
+	return nil
+}
+
+// Reads a recording and converts it to a whole note sample
+func newBuildSample(filename string) (*buildSample, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	wave, err := DecodeWave(data)
+	if err != nil {
+		return nil, err
+	}
+	buf := wave.Samples
+	if wave.SampleRate != SampleRate {
+		step := float64(wave.SampleRate) / SampleRate64
+		buf = resample(buf, step, int(float64(len(buf))/step))
+	}
+	buf = trimSilence(buf)
+	if len(buf) == 0 {
+		return nil, errors.New("recording is silent")
+	}
+
+	sample := &buildSample{
+		source: filepath.Base(filename),
+		level:  1,
+	}
+	base := filepath.Base(filename)
+	base = base[:len(base)-len(filepath.Ext(base))]
+	if name, level, ok := parseSampleName(base + ".wav"); ok {
+		sample.level = level
+		if number, err := sfzKey(name); err == nil {
+			sample.number = number
+		}
+	}
+	if sample.number == 0 {
+		if match := noteNamePattern.FindStringSubmatch(base); match != nil {
+			sample.number, _ = sfzKey(match[1])
+		}
+	}
+	if sample.number == 0 {
+		// detect note from recording
+		freq := detectPitch(buf)
+		if freq == 0 {
+			return nil, errors.New("unable to detect pitch")
+		}
+		sample.pitch = freq
+		sample.number = int(math.Floor(69 + 12*math.Log2(freq/440) + 0.5))
+		// tune to the exact note frequency
+		step := 440 * math.Pow(2, float64(sample.number-69)/12) / freq
+		buf = resample(buf, step, int(float64(len(buf))/step))
+	}
+	if sample.number < 12 || sample.number > 119 {
+		return nil, fmt.Errorf("note %d is out of range", sample.number)
+	}
+
+	// pad or trim to whole note
+	if len(buf) < wholeNote {
+		buf = append(buf, make([]int16, wholeNote-len(buf))...)
+	} else if len(buf) > wholeNote {
+		buf = buf[:wholeNote]
+		for i := 0; i < buildFade; i++ {
+			n := wholeNote - buildFade + i
+			buf[n] = int16(float64(buf[n]) * float64(buildFade-i) / buildFade)
+		}
+	}
+	sample.buf = buf
+	return sample, nil
+}
+
+// Removes silence from start and end of buf
+func trimSilence(buf []int16) []int16 {
+	start, end := 0, len(buf)
+	for start < end && math.Abs(float64(buf[start])) < buildSilence {
+		start++
+	}
+	for end > start && math.Abs(float64(buf[end-1])) < buildSilence {
+		end--
+	}
+	return buf[start:end]
+}
+
+// Normalizes loudness of samples. Velocity layers of a note get the gain
+// of the loudest layer, so softer layers keep their recorded dynamics.
+func normalizeSamples(samples []*buildSample) {
+	gains := make(map[int]float64) // gain by note
+	for _, sample := range samples {
+		var sum, peak float64
+		for _, bar := range sample.buf {
+			bar64 := float64(bar)
+			sum += bar64 * bar64
+			peak = math.Max(peak, math.Abs(bar64))
+		}
+		rms := math.Sqrt(sum / float64(len(sample.buf)))
+		if rms == 0 {
+			continue
+		}
+		gain := math.Min(buildLevel/rms, buildPeak/peak)
+		if g, found := gains[sample.number]; !found || gain < g {
+			gains[sample.number] = gain
+		}
+	}
+	for _, sample := range samples {
+		gain := gains[sample.number]
+		for i, bar := range sample.buf {
+			sample.buf[i] = int16(float64(bar) * gain)
+		}
+	}
+}
+
+// Returns voice file note name of MIDI note number like C4 or Bb3
+func voiceNoteName(number int) string {
+	return fmt.Sprintf("%s%d", voiceNoteNames[number%12], number/12-1)
+}
+
+// Detects fundamental frequency of buf with YIN pitch detection,
+// returns 0 if no pitch is found
+func detectPitch(buf []int16) float64 {
+	const (
+		window    = 4096
+		minLag    = SampleRate / 4200 // highest piano note
+		maxLag    = SampleRate / 27   // lowest piano note
+		threshold = 0.15
+	)
+	// skip the attack of the note
+	start := len(buf) / 10
+	if start+window+maxLag > len(buf) {
+		start = len(buf) - window - maxLag
+		if start < 0 {
+			return 0
+		}
+	}
+	x := buf[start:]
+	diff := make([]float64, maxLag+1)
+	for lag := 1; lag <= maxLag; lag++ {
+		var sum float64
+		for i := 0; i < window; i++ {
+			d := float64(x[i]) - float64(x[i+lag])
+			sum += d * d
+		}
+		diff[lag] = sum
+	}
+	// cumulative mean normalized difference
+	cmnd := make([]float64, maxLag+1)
+	cmnd[0] = 1
+	var total float64
+	for lag := 1; lag <= maxLag; lag++ {
+		total += diff[lag]
+		if total == 0 {
+			cmnd[lag] = 1
+			continue
+		}
+		cmnd[lag] = diff[lag] * float64(lag) / total
+	}
+	for lag := minLag; lag < maxLag; lag++ {
+		if cmnd[lag] >= threshold {
+			continue
+		}
+		for lag+1 < maxLag && cmnd[lag+1] < cmnd[lag] {
+			lag++
+		}
+		// parabolic interpolation of the minimum
+		pos := float64(lag)
+		a, b, c := cmnd[lag-1], cmnd[lag], cmnd[lag+1]
+		if d := a - 2*b + c; d != 0 {
+			pos += (a - c) / (2 * d)
+		}
+		return SampleRate64 / pos
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+package beep
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+func Example_detectPitch() {
+	// harmonic tones with a little noise
+	random := rand.New(rand.NewSource(1))
+	for _, freq := range []float64{110, 440, 1046.5} {
+		buf := make([]int16, SampleRate)
+		for i := range buf {
+			t := 2 * math.Pi * freq * float64(i) / SampleRate64
+			bar := 6000*math.Sin(t) + 3000*math.Sin(2*t) + 1500*math.Sin(3*t)
+			buf[i] = int16(bar + random.Float64()*200 - 100)
+		}
+		pitch := detectPitch(buf)
+		number := int(math.Floor(69 + 12*math.Log2(pitch/440) + 0.5))
+		fmt.Println(voiceNoteName(number))
+	}
+
+	// Output:
+	// A2
+	// A4
+	// C6
+}
@@ -0,0 +1,437 @@
+package beep
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// VoiceCache enables caching decoded voice file samples in the cache
+// directory, also enabled by 'voice-cache = true' in the config file
+var VoiceCache bool
+
+// Natural voice samples loaded from a voice file.
+//
+// A voice file is a ZIP file of WAV files named by note, for example C4.wav.
+// Notes can have velocity layers and round-robin alternates:
+//
+//	C4_v1.wav .. C4_v4.wav        - velocity layers, v1 is the softest
+//	C4_v1_r1.wav, C4_v1_r2.wav    - alternates of a layer, played in turn
+//	C4_r1.wav, C4_r2.wav          - alternates of a note without layers
+//
+// Only the file list is read when the voice is loaded, samples are decoded
+// when a key is played first.
+type voicePack struct {
+	filename string
+	reader   *zip.ReadCloser        // open voice file, nil after Close
+	files    map[string]*zip.File   // sample files of the open voice file
+	manifest *VoiceManifest         // nil if voice file has no manifest
+	notes    map[rune][]*voiceLayer // layers of each key, softest first
+	cache    bool                   // cache decoded samples
+	cacheDir string                 // decoded sample cache, empty until first use
+	detune   map[rune]float64       // pitch-shift step of keys retuned from equal temperament
+	decoded  bool                   // a sample was decoded
+	mutex    sync.Mutex
+}
+
+// Velocity layer of a note
+type voiceLayer struct {
+	level      int            // layer number from file name
+	alternates []*voiceSample // round-robin samples
+	next       int            // next alternate to play
+}
+
+// A note sample, decoded on first use
+type voiceSample struct {
+	name   string       // sample file name in voice file
+	source *voiceSample // recorded sample that is pitch-shifted to this note
+	step   float64      // pitch-shift step of source
+	buf    []int16      // decoded whole note, nil until loaded
+	failed bool         // unable to load
+}
+
+// Loads natural voice file, returns error if the file can't be opened
+// or its manifest is invalid. The voice file is kept open to decode samples.
+func loadVoicePack(filename string, noteKeyMap map[string]rune) (*voicePack, error) {
+	voiceFile, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readVoiceManifest(&voiceFile.Reader)
+	if err != nil {
+		voiceFile.Close()
+		return nil, err
+	}
+
+	pack := &voicePack{
+		filename: filename,
+		reader:   voiceFile,
+		files:    make(map[string]*zip.File),
+		manifest: manifest,
+		notes:    make(map[rune][]*voiceLayer),
+		cache:    VoiceCache || readConfig()["voice-cache"] == "true",
+	}
+	for _, zfile := range voiceFile.File {
+		if !strings.HasSuffix(zfile.Name, ".wav") {
+			continue
+		}
+		noteName, level, ok := parseSampleName(manifest.noteName(zfile.Name))
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Invalid sample file name in voice file:", zfile.Name)
+			continue
+		}
+		key, found := noteKeyMap[noteName]
+		if !found {
+			fmt.Fprintln(os.Stderr, "Unknown note name in voice file:", noteName)
+			continue
+		}
+		pack.files[zfile.Name] = zfile
+		pack.add(key, level, &voiceSample{name: zfile.Name})
+	}
+	return pack, nil
+}
+
+// Close closes the voice file, samples that are not decoded yet are
+// not found after the voice file is closed
+func (v *voicePack) Close() error {
+	if v == nil {
+		return nil
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.files = nil
+	if v.reader == nil {
+		return nil
+	}
+	err := v.reader.Close()
+	v.reader = nil
+	return err
+}
+
+// Parses sample file name like C4.wav, C4_v2.wav or C4_v2_r1.wav,
+// returns note name and velocity layer number
+func parseSampleName(name string) (string, int, bool) {
+	parts := strings.Split(strings.TrimSuffix(name, ".wav"), "_")
+	level := 1
+	for _, part := range parts[1:] {
+		if len(part) < 2 {
+			return "", 0, false
+		}
+		n, err := strconv.Atoi(part[1:])
+		if err != nil {
+			return "", 0, false
+		}
+		switch part[0] {
+		case 'v':
+			level = n
+		case 'r':
+			// alternates are played in file order
+		default:
+			return "", 0, false
+		}
+	}
+	return parts[0], level, true
+}
+
+// Adds sample to velocity layer of key
+func (v *voicePack) add(key rune, level int, sample *voiceSample) {
+	for _, layer := range v.notes[key] {
+		if layer.level == level {
+			layer.alternates = append(layer.alternates, sample)
+			return
+		}
+	}
+	layers := append(v.notes[key], &voiceLayer{
+		level:      level,
+		alternates: []*voiceSample{sample},
+	})
+	sort.Slice(layers, func(i, j int) bool {
+		return layers[i].level < layers[j].level
+	})
+	v.notes[key] = layers
+}
+
+// Found returns true if a sample of the voice file can be decoded
+func (v *voicePack) found() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	keys := make([]rune, 0, len(v.notes))
+	for key := range v.notes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		if v.decoded {
+			break
+		}
+		for _, layer := range v.notes[key] {
+			if v.load(layer.alternates[0]) != nil {
+				break
+			}
+		}
+	}
+	return v.decoded
+}
+
+// Returns a copy of note sample for the velocity layer, cycling round-robin
+// alternates, and amplitude of the velocity within the layer. Voice without
+// voice file has no samples.
+func (v *voicePack) sample(note *Note) ([]int16, float64, bool) {
+	if v == nil {
+		return nil, 0, false
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	layers, found := v.notes[note.key]
+	if !found || len(layers) == 0 {
+		return nil, 0, false
+	}
+	velocity := noteVelocity(note)
+	index := (velocity - 1) * len(layers) / 127
+	if index >= len(layers) {
+		index = len(layers) - 1
+	}
+	layer := layers[index]
+	sample := layer.alternates[layer.next]
+	layer.next = (layer.next + 1) % len(layer.alternates)
+	bufNote := v.load(sample)
+	if bufNote == nil {
+		return nil, 0, false
+	}
+
+	buf := make([]int16, len(bufNote))
+	copy(buf, bufNote) // get a copy of the note
+
+	// highest velocity of the layer plays the sample at full amplitude
+	top := (index + 1) * 127 / len(layers)
+	amplitude := float64(velocity) / float64(top)
+	if amplitude > 1 {
+		amplitude = 1
+	}
+	if note.velocity > 0 {
+		// MIDI velocity is already applied to note volume
+		amplitude *= 127 / float64(velocity)
+	}
+	return buf, amplitude, true
+}
+
+// Returns decoded sample from memory, cache or voice file, nil if it can't be loaded
+func (v *voicePack) load(sample *voiceSample) []int16 {
+	if sample.buf != nil || sample.failed {
+		return sample.buf
+	}
+	cacheFile := v.cacheFile(sample)
+	if len(cacheFile) > 0 {
+		if data, err := ioutil.ReadFile(cacheFile); err == nil && len(data) >= wholeNote*2 && len(data)%2 == 0 {
+			sample.buf = byteToInt16Buf(data)
+			v.decoded = true
+			return sample.buf
+		}
+	}
+	var err error
+	if sample.source != nil {
+		if source := v.load(sample.source); source != nil {
+			length := int(float64(len(source)) / sample.step)
+			if length < wholeNote {
+				length = wholeNote
+			}
+			sample.buf = resample(source, sample.step, length)
+			trimWave(sample.buf)
+		} else {
+			err = errors.New("unable to load source sample")
+		}
+	} else {
+		sample.buf, err = v.decode(sample.name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid sample file %s: %v\n", sample.name, err)
+		sample.failed = true
+		return nil
+	}
+	v.decoded = true
+	if len(cacheFile) > 0 {
+		writeCacheFile(cacheFile, int16ToByteBuf(sample.buf))
+	}
+	return sample.buf
+}
+
+// Decodes a sample file of the voice file, at least a whole note long
+func (v *voicePack) decode(name string) ([]int16, error) {
+	zfile, found := v.files[name]
+	if !found {
+		return nil, errors.New("file not found in voice file")
+	}
+	file, err := zfile.Open()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	wave, err := DecodeWave(data)
+	if err != nil {
+		return nil, err
+	}
+	if wave.SampleRate != SampleRate {
+		return nil, fmt.Errorf("unsupported sample rate: %d", wave.SampleRate)
+	}
+	if err := v.manifest.check(name, data, wave); err != nil {
+		return nil, err
+	}
+	buf := wave.Samples
+	if v.manifest != nil && v.manifest.Tuning > 0 && v.manifest.Tuning != 440 {
+		// retune samples to A4 440 hertz
+		buf = resample(buf, 440/v.manifest.Tuning, int(float64(len(buf))*v.manifest.Tuning/440))
+	}
+	if len(buf) < wholeNote {
+		fmt.Fprintln(os.Stderr, "Sample note duration must be 90112 samples long.")
+		// too short, sample should be a whole note
+		buf = append(buf, make([]int16, wholeNote-len(buf))...)
+	}
+	trimWave(buf)
+	return buf, nil
+}
+
+// Returns cache file name of a sample, empty if caching is disabled.
+// Cache directories are named by voice file path, size and modification
+// time, the voice file isn't read to find its cache.
+func (v *voicePack) cacheFile(sample *voiceSample) string {
+	if !v.cache {
+		return ""
+	}
+	if len(v.cacheDir) == 0 {
+		info, err := os.Stat(v.filename)
+		if err != nil {
+			return ""
+		}
+		filename, _ := filepath.Abs(v.filename)
+		key := fmt.Sprintf("%s %d %d", filename, info.Size(), info.ModTime().UnixNano())
+		sum := sha256.Sum256([]byte(key))
+		v.cacheDir = filepath.Join(HomeDir(), "cache", hex.EncodeToString(sum[:8]))
+	}
+	name := sample.name
+	if sample.source != nil {
+		name = fmt.Sprintf("%s_%.6f", sample.source.name, sample.step)
+	}
+	return filepath.Join(v.cacheDir, strings.Replace(name, "/", "_", -1)+".pcm")
+}
+
+// Writes cache file through a temporary file, errors are ignored
+func writeCacheFile(filename string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return
+	}
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// Retunes samples from equal temperament to tuning, nil restores equal temperament
+func (v *voicePack) retune(tuning *Tuning) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.detune == nil {
+		v.detune = make(map[rune]float64)
+	}
+	for key, layers := range v.notes {
+		number, found := keyMidiNoteMap[key]
+		if !found {
+			continue
+		}
+		step := 1.0
+		if tuning != nil {
+			step = tuning.detune(int(number))
+		}
+		previous, found := v.detune[key]
+		if !found {
+			previous = 1
+		}
+		if step == previous {
+			continue
+		}
+		v.detune[key] = step
+		for _, layer := range layers {
+			for i, sample := range layer.alternates {
+				source, shift := sample, 1.0
+				if sample.source != nil {
+					source, shift = sample.source, sample.step/previous
+				}
+				if math.Abs(shift*step-1) < 1e-9 {
+					// back to the recorded sample
+					layer.alternates[i] = source
+					continue
+				}
+				layer.alternates[i] = &voiceSample{
+					name:   source.name,
+					source: source,
+					step:   shift * step,
+				}
+			}
+		}
+	}
+}
+
+// Fills keys missing in natural voice by pitch-shifting the nearest recorded note,
+// so sparse voice files sound the same on all keys
+func (v *voicePack) fill(keyNoteMap map[rune]string) {
+	if len(v.notes) == 0 {
+		return
+	}
+	recorded := make(map[rune]int) // recorded keys with MIDI note numbers
+	for key := range v.notes {
+		if number, found := keyMidiNoteMap[key]; found {
+			recorded[key] = int(number)
+		}
+	}
+	for key := range keyNoteMap {
+		if _, found := v.notes[key]; found {
+			continue
+		}
+		number, found := keyMidiNoteMap[key]
+		if !found {
+			continue
+		}
+		var source rune
+		distance := 0
+		for k, n := range recorded {
+			d := int(number) - n
+			if d < 0 {
+				d = -d
+			}
+			// prefer shifting down from a higher note on ties
+			if source == 0 || d < distance || (d == distance && n > recorded[source]) {
+				source = k
+				distance = d
+			}
+		}
+		if source == 0 {
+			continue
+		}
+		step := math.Pow(2, float64(int(number)-recorded[source])/12)
+		for _, layer := range v.notes[source] {
+			for _, alternate := range layer.alternates {
+				v.add(key, layer.level, &voiceSample{
+					name:   alternate.name,
+					source: alternate,
+					step:   step,
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package beep
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func Example_voicePack() {
+	pack := &voicePack{notes: make(map[rune][]*voiceLayer)}
+	key := rune(3000 + 'q')
+	for i, name := range []string{"C4_v2_r1.wav", "C4_v1.wav", "C4_v2_r2.wav"} {
+		_, level, _ := parseSampleName(name)
+		pack.add(key, level, &voiceSample{buf: []int16{int16(i)}})
+	}
+	// soft notes play layer v1, loud notes alternate v2 samples
+	for _, amplitude := range []int{3, 9, 9, 9} {
+		buf, gain, _ := pack.sample(&Note{key: key, amplitude: amplitude})
+		fmt.Printf("amplitude %d: sample %d gain %.2f\n", amplitude, buf[0], gain)
+	}
+	// MIDI note volume is scaled by velocity, gain scales it within the layer
+	buf, gain, _ := pack.sample(&Note{key: key, velocity: 32})
+	fmt.Printf("velocity 32: sample %d gain %.2f volume %.2f\n", buf[0], gain, gain*32/127)
+
+	// violin selected by VV without voice file plays computer voice
+	var missing *voicePack
+	_, _, found := missing.sample(&Note{key: key})
+	fmt.Println(found)
+
+	// Output:
+	// amplitude 3: sample 1 gain 0.67
+	// amplitude 9: sample 0 gain 1.00
+	// amplitude 9: sample 2 gain 1.00
+	// amplitude 9: sample 0 gain 1.00
+	// velocity 32: sample 1 gain 2.02 volume 0.51
+	// false
+}
+
+func Example_loadVoicePack() {
+	dir, _ := ioutil.TempDir("", "beep")
+	defer os.RemoveAll(dir)
+	var wav bytes.Buffer
+	NewWaveHeader(1, SampleRate, 16, (wholeNote+1000)*2).WriteHeader(&wav)
+	wav.Write(int16ToByteBuf(make([]int16, wholeNote+1000)))
+	noteKeyMap := map[string]rune{"C4": 3000 + 'q', "D4": 3000 + 'w'}
+
+	for _, files := range [][]string{{"C4.wav", "D4.wav"}, {"D4.wav"}} {
+		var buf bytes.Buffer
+		voiceFile := zip.NewWriter(&buf)
+		for _, name := range files {
+			f, _ := voiceFile.Create(name)
+			if name == "C4.wav" {
+				f.Write(wav.Bytes())
+			} else {
+				f.Write([]byte("not a wave file"))
+			}
+		}
+		voiceFile.Close()
+		filename := filepath.Join(dir, "piano.zip")
+		ioutil.WriteFile(filename, buf.Bytes(), 0644)
+
+		pack, err := loadVoicePack(filename, noteKeyMap)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		found := pack.found()
+		sample, _, _ := pack.sample(&Note{key: 3000 + 'q'})
+		// voice file is closed before it is replaced
+		fmt.Println(found, len(sample) > wholeNote, pack.Close())
+	}
+
+	// Output:
+	// true true <nil>
+	// false false <nil>
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -273,28 +272,30 @@ func (w *Web) serveDownloadVoice(res http.ResponseWriter, req *http.Request) {
 
 // Export to WAV file
 func (w *Web) serveExportWave(res http.ResponseWriter, req *http.Request) {
-	defer func() {
-		w.music.output = ""
-	}()
 	type exportWaveRequest struct {
 		Output   string
 		Notation string
+		Loudness float64 // LUFS, 0 uses loudness of the music
 	}
 	request := &exportWaveRequest{}
 	w.jsonRequest(request, req)
+	loudness := request.Loudness
+	if loudness == 0 {
+		loudness = w.music.loudness
+	}
 
 	notation := bytes.NewBuffer([]byte(request.Notation))
 	reader := bufio.NewReader(notation)
-	w.music.output = filepath.Join(HomeDir(), "export", request.Output)
-	os.MkdirAll(filepath.Dir(w.music.output), 0755)
-	go w.music.Play(reader, 100)
+	output := filepath.Join(HomeDir(), "export", request.Output)
+	os.MkdirAll(filepath.Dir(output), 0755)
+	go w.music.play(reader, 100, output, loudness)
 	w.music.Wait()
 
 	type exportWaveResponse struct {
 		Result string
 	}
 	response := exportWaveResponse{
-		Result: "WAV file has been save to: " + w.music.output,
+		Result: "WAV file has been save to: " + output,
 	}
 	w.jsonResponse(response, res)
 }
@@ -329,67 +330,6 @@ func (w *Web) jsonResponse(response interface{}, res http.ResponseWriter) {
 	}
 }
 
-// DownloadVoiceFiles downloads natural voice files
-func DownloadVoiceFiles(music *Music, writer io.Writer, names []string) {
-	dir := filepath.Join(HomeDir(), "voices")
-	if len(names) == 0 {
-		names = []string{"piano", "violin"}
-	}
-	for _, name := range names {
-		if !strings.HasSuffix(name, ".zip") {
-			name += ".zip"
-		}
-		url := "http://bmrust.com/dl/beep/voices/" + name
-		fmt.Fprintf(writer, "Downloading '%s'", url)
-
-		// locate file
-		resp, err := http.Head(url)
-		if err != nil {
-			fmt.Fprintln(writer, " Error:", err)
-			continue
-		}
-		if resp.StatusCode != http.StatusOK {
-			fmt.Fprintln(writer, "Error locating file. Status:", resp.StatusCode)
-			continue
-		}
-		fmt.Fprintf(writer, " %s bytes ...\n", numberComma(resp.ContentLength))
-
-		// fetch file
-		resp, err = http.Get(url)
-		if err != nil {
-			fmt.Fprintln(writer, "Error downloading file:", err)
-			continue
-		}
-		if resp.StatusCode != http.StatusOK {
-			fmt.Fprintln(writer, "Error downloading. Status:", resp.StatusCode)
-			continue
-		}
-		defer resp.Body.Close()
-
-		// read file
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Fprintln(writer, "Error reading file:", err)
-			continue
-		}
-
-		// save file
-		os.MkdirAll(dir, 0755)
-		filename := filepath.Join(dir, name)
-		err = ioutil.WriteFile(filename, body, 0644)
-		if err != nil {
-			fmt.Fprintln(writer, "Error saving file:", err)
-			continue
-		}
-
-		fmt.Fprintf(writer, "  Saving %s\n", filename)
-	}
-
-	// reload voices
-	music.piano = NewPiano()
-	music.violin = NewViolin()
-}
-
 // All HTML templates
 var webTemplates = `{{define "header"}}
 <!DOCTYPE html>